@@ -0,0 +1,347 @@
+package alerts
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/units"
+)
+
+// The ways that a reading can be compared to the threshold of a rule
+var comparisons = map[string]func(float64, float64) bool{
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+}
+
+type Rule struct {
+	Name string
+	// The station that the rule watches, or every station if empty
+	Station   string
+	Sensor    string
+	Compare   string
+	Threshold float64
+	// The unit of the threshold and hysteresis, or the unit of the sensor if
+	// empty
+	Unit string
+	// How long the threshold has to be crossed before the alert fires
+	For time.Duration
+	// How far back past the threshold a reading has to be to resolve the alert
+	Hysteresis float64
+	Quiet      *QuietHours
+	// The notifiers that the alert is sent to, or every notifier if empty
+	Notify []string
+}
+
+// Notifications are held back between Start and End, which are offsets from
+// midnight. End may be before Start to wrap around midnight.
+type QuietHours struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Parse quiet hours such as "22:00-07:00"
+func ParseQuietHours(value string) (*QuietHours, error) {
+	var start_h, start_m, end_h, end_m int
+	_, err := fmt.Sscanf(value, "%d:%d-%d:%d", &start_h, &start_m, &end_h, &end_m)
+	if err != nil || start_h > 23 || end_h > 23 || start_m > 59 || end_m > 59 {
+		return nil, fmt.Errorf("Invalid quiet hours %v", value)
+	}
+	return &QuietHours{
+		Start: time.Duration(start_h)*time.Hour + time.Duration(start_m)*time.Minute,
+		End:   time.Duration(end_h)*time.Hour + time.Duration(end_m)*time.Minute,
+	}, nil
+}
+
+func (self *QuietHours) Contains(t time.Time) bool {
+	if self == nil {
+		return false
+	}
+	t = t.Local()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if self.Start <= self.End {
+		return offset >= self.Start && offset < self.End
+	}
+	return offset >= self.Start || offset < self.End
+}
+
+func (self *Rule) Validate() error {
+	if self.Name == "" {
+		return fmt.Errorf("Alerts need a name")
+	}
+	if self.Sensor == "" {
+		return fmt.Errorf("Alert %v: missing sensor", self.Name)
+	}
+	if _, exists := comparisons[self.Compare]; !exists {
+		return fmt.Errorf("Alert %v: unknown comparison %v", self.Name, self.Compare)
+	}
+	if self.Unit != "" && !units.IsKnown(self.Unit) {
+		return fmt.Errorf("Alert %v: unknown unit %v", self.Name, self.Unit)
+	}
+	if self.Hysteresis < 0 {
+		return fmt.Errorf("Alert %v: hysteresis cannot be negative", self.Name)
+	}
+	return nil
+}
+
+// Get the threshold and hysteresis of the rule in the unit of its sensor
+func (self *Rule) limits() (float64, float64, error) {
+	sensor_unit := database.GetUnit(self.Sensor)
+	if self.Unit == "" || sensor_unit == "" || self.Unit == sensor_unit {
+		return self.Threshold, self.Hysteresis, nil
+	}
+	threshold, err := units.Convert(self.Threshold, self.Unit, sensor_unit)
+	if err != nil {
+		return 0, 0, err
+	}
+	// The hysteresis is a difference, so offsets such as C to F cancel out
+	edge, err := units.Convert(self.Threshold+self.Hysteresis, self.Unit, sensor_unit)
+	if err != nil {
+		return 0, 0, err
+	}
+	return threshold, edge - threshold, nil
+}
+
+// An alert of a rule for one station
+type Alert struct {
+	Rule      string
+	Station   string
+	Sensor    string
+	Compare   string
+	Threshold float64
+	Unit      string
+	Value     float64
+	// When the threshold was first crossed
+	Since time.Time
+	// When the alert fired or resolved
+	Time   time.Time
+	Active bool
+}
+
+func formatValue(value float64, unit string) string {
+	text := strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+	if unit == "" {
+		return text
+	}
+	return text + " " + unit
+}
+
+func (self Alert) Message() string {
+	reading := fmt.Sprintf("%v is %v", self.Sensor, formatValue(self.Value, self.Unit))
+	if !self.Active {
+		return fmt.Sprintf("%v resolved on %v: %v", self.Rule, self.Station, reading)
+	}
+	return fmt.Sprintf(
+		"%v on %v: %v (%v %v)",
+		self.Rule, self.Station, reading, self.Compare, formatValue(self.Threshold, self.Unit),
+	)
+}
+
+type state struct {
+	alert Alert
+	// When the threshold started being crossed, before the alert fires
+	pending time.Time
+	// Whether the last notification sent was for the alert firing
+	notified bool
+}
+
+// The clock that quiet hours are checked against
+var now = time.Now
+
+var lock sync.Mutex
+var rules []Rule
+var notifiers = map[string]Notifier{}
+var states = map[string]*state{}
+
+// Configure replaces the rules and notifiers. Alerts of rules that still exist
+// keep their state.
+func Configure(new_rules []Rule, new_notifiers []Notifier) error {
+	names := make(map[string]bool)
+	by_name := make(map[string]Notifier)
+	for _, notifier := range new_notifiers {
+		if _, exists := by_name[notifier.Name()]; exists {
+			return fmt.Errorf("Duplicate notifier %v", notifier.Name())
+		}
+		by_name[notifier.Name()] = notifier
+	}
+	for i := range new_rules {
+		rule := &new_rules[i]
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("Duplicate alert %v", rule.Name)
+		}
+		names[rule.Name] = true
+		for _, name := range rule.Notify {
+			if _, exists := by_name[name]; !exists {
+				return fmt.Errorf("Alert %v: unknown notifier %v", rule.Name, name)
+			}
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	rules = new_rules
+	notifiers = by_name
+	for key, s := range states {
+		if !names[s.alert.Rule] {
+			delete(states, key)
+		}
+	}
+	return nil
+}
+
+// Evaluate checks a condition against every rule, and sends the notifications
+// of alerts that fired or resolved
+func Evaluate(condition database.Condition) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Station != "" && rule.Station != condition.Station {
+			continue
+		}
+		key := rule.Name + "\x00" + condition.Station
+		s, exists := states[key]
+		if !exists {
+			s = &state{alert: Alert{Rule: rule.Name, Station: condition.Station}}
+			states[key] = s
+		}
+		s.alert.Sensor = rule.Sensor
+		s.alert.Compare = rule.Compare
+		s.alert.Threshold = rule.Threshold
+		s.alert.Unit = rule.Unit
+		if s.alert.Unit == "" {
+			s.alert.Unit = database.GetUnit(rule.Sensor)
+		}
+
+		if value, exists := condition.Sensors[rule.Sensor]; exists {
+			threshold, hysteresis, err := rule.limits()
+			if err != nil {
+				logrus.Warnf("Alert %v: %v", rule.Name, err)
+				continue
+			}
+			s.update(rule, value, threshold, hysteresis, condition.Time)
+			s.alert.Value = value
+			if s.alert.Unit != database.GetUnit(rule.Sensor) {
+				if converted, err := units.Convert(value, database.GetUnit(rule.Sensor), s.alert.Unit); err == nil {
+					s.alert.Value = converted
+				}
+			}
+		}
+
+		// Notifications held back by quiet hours are sent once they end, if
+		// the alert hasn't already resolved
+		if s.notified != s.alert.Active && !rule.Quiet.Contains(now()) {
+			s.notified = s.alert.Active
+			send(rule, s.alert)
+		}
+	}
+}
+
+func (self *state) update(rule *Rule, value float64, threshold float64, hysteresis float64, t time.Time) {
+	compare := comparisons[rule.Compare]
+
+	if self.alert.Active {
+		// The reading has to come back past the threshold by the hysteresis
+		edge := threshold + hysteresis
+		if rule.Compare == ">" || rule.Compare == ">=" {
+			edge = threshold - hysteresis
+		}
+		if !compare(value, edge) {
+			self.alert.Active = false
+			self.alert.Time = t
+			logrus.Infof("Alert %v resolved on %v", rule.Name, self.alert.Station)
+		}
+		return
+	}
+
+	if !compare(value, threshold) {
+		self.pending = time.Time{}
+		return
+	}
+	if self.pending.IsZero() {
+		self.pending = t
+	}
+	if t.Sub(self.pending) >= rule.For {
+		self.alert.Active = true
+		self.alert.Since = self.pending
+		self.alert.Time = t
+		self.pending = time.Time{}
+		logrus.Infof("Alert %v fired on %v", rule.Name, self.alert.Station)
+	}
+}
+
+type delivery struct {
+	notifier Notifier
+	alert    Alert
+}
+
+// Notifications are sent in order, so that an alert never resolves before it
+// fires
+var deliveries = make(chan delivery, 100)
+
+func init() {
+	go func() {
+		for d := range deliveries {
+			if err := d.notifier.Notify(d.alert); err != nil {
+				logrus.Errorf("Could not send alert %v to %v: %v", d.alert.Rule, d.notifier.Name(), err)
+			}
+		}
+	}()
+}
+
+func send(rule *Rule, alert Alert) {
+	targets := []Notifier{}
+	if len(rule.Notify) == 0 {
+		for _, notifier := range notifiers {
+			targets = append(targets, notifier)
+		}
+	} else {
+		for _, name := range rule.Notify {
+			targets = append(targets, notifiers[name])
+		}
+	}
+	for _, notifier := range targets {
+		select {
+		case deliveries <- delivery{notifier: notifier, alert: alert}:
+		default:
+			logrus.Errorf("Dropping alert %v to %v: too many alerts are waiting to be sent", alert.Rule, notifier.Name())
+		}
+	}
+}
+
+// Active gets the alerts of a station that are firing
+func Active(station string) []Alert {
+	lock.Lock()
+	defer lock.Unlock()
+
+	active := []Alert{}
+	for _, s := range states {
+		if s.alert.Active && s.alert.Station == station {
+			active = append(active, s.alert)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].Since.Before(active[j].Since)
+	})
+	return active
+}
+
+// Watch evaluates every condition that a station receives
+func Watch(updates chan database.Condition) {
+	go func() {
+		for condition := range updates {
+			Evaluate(condition)
+		}
+	}()
+}
@@ -0,0 +1,442 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+// Records the alerts that it is sent
+type recorder struct {
+	name   string
+	alerts chan Alert
+}
+
+func newRecorder(name string) *recorder {
+	return &recorder{name: name, alerts: make(chan Alert, 10)}
+}
+
+func (self *recorder) Name() string {
+	return self.name
+}
+
+func (self *recorder) Notify(alert Alert) error {
+	self.alerts <- alert
+	return nil
+}
+
+func (self *recorder) expect(t *testing.T, active bool) Alert {
+	t.Helper()
+	select {
+	case alert := <-self.alerts:
+		if alert.Active != active {
+			t.Fatalf("expected active %v, got %+v", active, alert)
+		}
+		return alert
+	case <-time.After(time.Second):
+		t.Fatalf("expected an alert with active %v", active)
+	}
+	return Alert{}
+}
+
+func (self *recorder) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case alert := <-self.alerts:
+		t.Fatalf("expected no alert, got %+v", alert)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func configure(t *testing.T, rule Rule) *recorder {
+	t.Helper()
+	notifier := newRecorder("recorder")
+	if err := Configure([]Rule{rule}, []Notifier{notifier}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		Configure(nil, nil)
+		now = time.Now
+	})
+	return notifier
+}
+
+var start = time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+
+func evaluate(minutes int, sensor string, value float64) {
+	condition := database.NewCondition("roof", start.Add(time.Duration(minutes)*time.Minute))
+	condition.Sensors[sensor] = value
+	Evaluate(condition)
+}
+
+func TestFiresOnceAfterFor(t *testing.T) {
+	notifier := configure(t, Rule{
+		Name:      "Heat",
+		Sensor:    "temp",
+		Compare:   ">",
+		Threshold: 30,
+		For:       time.Minute * 5,
+	})
+
+	evaluate(0, "temp", 31)
+	evaluate(2, "temp", 32)
+	notifier.expectNone(t)
+	if len(Active("roof")) != 0 {
+		t.Error("the alert is active before it has been crossed for 5 minutes")
+	}
+
+	evaluate(5, "temp", 31)
+	alert := notifier.expect(t, true)
+	if !alert.Since.Equal(start) {
+		t.Errorf("expected the alert to be active since %v, got %v", start, alert.Since)
+	}
+	if alert.Value != 31 {
+		t.Errorf("expected a value of 31, got %v", alert.Value)
+	}
+	if len(Active("roof")) != 1 {
+		t.Error("expected the alert to be active")
+	}
+
+	evaluate(6, "temp", 33)
+	evaluate(7, "temp", 34)
+	notifier.expectNone(t)
+}
+
+func TestDipResetsFor(t *testing.T) {
+	notifier := configure(t, Rule{
+		Name:      "Gusts",
+		Sensor:    "windgustspd-2m",
+		Compare:   ">",
+		Threshold: 60,
+		For:       time.Minute * 5,
+	})
+
+	evaluate(0, "windgustspd-2m", 70)
+	evaluate(3, "windgustspd-2m", 50)
+	evaluate(6, "windgustspd-2m", 70)
+	notifier.expectNone(t)
+
+	evaluate(11, "windgustspd-2m", 70)
+	alert := notifier.expect(t, true)
+	if want := start.Add(time.Minute * 6); !alert.Since.Equal(want) {
+		t.Errorf("expected the alert to be active since %v, got %v", want, alert.Since)
+	}
+}
+
+func TestResolvesPastHysteresis(t *testing.T) {
+	notifier := configure(t, Rule{
+		Name:       "Freeze",
+		Sensor:     "temp",
+		Compare:    "<=",
+		Threshold:  0,
+		Hysteresis: 1,
+	})
+
+	evaluate(0, "temp", -1)
+	notifier.expect(t, true)
+
+	// Back above the threshold, but not past the hysteresis
+	evaluate(1, "temp", 0.5)
+	evaluate(2, "temp", 1)
+	notifier.expectNone(t)
+	if len(Active("roof")) != 1 {
+		t.Error("expected the alert to still be active")
+	}
+
+	evaluate(3, "temp", 1.5)
+	alert := notifier.expect(t, false)
+	if want := start.Add(time.Minute * 3); !alert.Time.Equal(want) {
+		t.Errorf("expected the alert to resolve at %v, got %v", want, alert.Time)
+	}
+	if len(Active("roof")) != 0 {
+		t.Error("expected the alert to have resolved")
+	}
+}
+
+func TestHysteresisInTheUnitOfTheRule(t *testing.T) {
+	notifier := configure(t, Rule{
+		Name:       "Freeze F",
+		Sensor:     "temp",
+		Compare:    "<=",
+		Threshold:  32,
+		Unit:       "F",
+		Hysteresis: 9,
+	})
+
+	// Temperatures are stored in C, so the alert resolves above 41 F (5 C)
+	evaluate(0, "temp", -1)
+	notifier.expect(t, true)
+	evaluate(1, "temp", 4)
+	notifier.expectNone(t)
+	evaluate(2, "temp", 6)
+	alert := notifier.expect(t, false)
+	if alert.Unit != "F" || alert.Value != 42.8 {
+		t.Errorf("expected the value in F, got %v %v", alert.Value, alert.Unit)
+	}
+}
+
+func TestQuietHoursHoldNotifications(t *testing.T) {
+	quiet, err := ParseQuietHours("22:00-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := configure(t, Rule{
+		Name:      "Night Freeze",
+		Sensor:    "temp",
+		Compare:   "<",
+		Threshold: 0,
+		Quiet:     quiet,
+	})
+
+	now = func() time.Time { return time.Date(2024, 6, 1, 23, 0, 0, 0, time.Local) }
+	evaluate(0, "temp", -1)
+	evaluate(1, "temp", -2)
+	notifier.expectNone(t)
+	if len(Active("roof")) != 1 {
+		t.Error("expected the alert to be active during quiet hours")
+	}
+
+	now = func() time.Time { return time.Date(2024, 6, 2, 7, 0, 0, 0, time.Local) }
+	evaluate(2, "temp", -3)
+	alert := notifier.expect(t, true)
+	if !alert.Since.Equal(start) {
+		t.Errorf("expected the alert to be active since %v, got %v", start, alert.Since)
+	}
+	notifier.expectNone(t)
+}
+
+func TestQuietHoursSkipResolvedAlerts(t *testing.T) {
+	quiet, err := ParseQuietHours("22:00-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := configure(t, Rule{
+		Name:      "Short Freeze",
+		Sensor:    "temp",
+		Compare:   "<",
+		Threshold: 0,
+		Quiet:     quiet,
+	})
+
+	now = func() time.Time { return time.Date(2024, 6, 1, 23, 0, 0, 0, time.Local) }
+	evaluate(0, "temp", -1)
+	evaluate(1, "temp", 2)
+
+	now = func() time.Time { return time.Date(2024, 6, 2, 7, 0, 0, 0, time.Local) }
+	evaluate(2, "temp", 2)
+	notifier.expectNone(t)
+}
+
+func TestParseQuietHours(t *testing.T) {
+	quiet, err := ParseQuietHours("22:30-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour int, minute int) time.Time {
+		return time.Date(2024, 6, 1, hour, minute, 0, 0, time.Local)
+	}
+	for _, tc := range []struct {
+		time  time.Time
+		quiet bool
+	}{
+		{at(22, 29), false},
+		{at(22, 30), true},
+		{at(3, 0), true},
+		{at(6, 59), true},
+		{at(7, 0), false},
+		{at(12, 0), false},
+	} {
+		if quiet.Contains(tc.time) != tc.quiet {
+			t.Errorf("expected %v to be quiet: %v", tc.time.Format("15:04"), tc.quiet)
+		}
+	}
+
+	for _, invalid := range []string{"", "22:00", "24:00-07:00", "22:60-07:00"} {
+		if _, err := ParseQuietHours(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	payloads := make(chan webhookPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		requests <- r
+		payloads <- payload
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook("pager", server.URL, map[string]string{"Authorization": "Bearer secret"})
+	err := webhook.Notify(Alert{
+		Rule:      "Freeze",
+		Station:   "roof",
+		Sensor:    "temp",
+		Compare:   "<=",
+		Threshold: 32,
+		Unit:      "F",
+		Value:     30.2,
+		Since:     start,
+		Time:      start,
+		Active:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-requests
+	if r.Method != "POST" {
+		t.Errorf("expected a POST, got %v", r.Method)
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected json, got %v", r.Header.Get("Content-Type"))
+	}
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("expected the configured headers, got %v", r.Header)
+	}
+
+	payload := <-payloads
+	if payload.Alert != "Freeze" || payload.Station != "roof" || payload.Sensor != "temp" {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	if payload.Status != "firing" || payload.Value != 30.2 || payload.Threshold != 32 {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	if want := "Freeze on roof: temp is 30.2 F (<= 32 F)"; payload.Message != want {
+		t.Errorf("expected the message %q, got %q", want, payload.Message)
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook("pager", server.URL, nil)
+	if err := webhook.Notify(Alert{Rule: "Freeze"}); err == nil {
+		t.Error("expected an error when the webhook fails")
+	}
+}
+
+// An email that was sent
+type sentMail struct {
+	server string
+	auth   smtp.Auth
+	from   string
+	to     []string
+	msg    string
+}
+
+func recordMail(t *testing.T, err error) chan sentMail {
+	sent := make(chan sentMail, 1)
+	sendMail = func(server string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		sent <- sentMail{server, auth, from, to, string(msg)}
+		return err
+	}
+	t.Cleanup(func() { sendMail = smtp.SendMail })
+	return sent
+}
+
+func TestNewEmail(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		server string
+		from   string
+		to     []string
+		valid  bool
+	}{
+		{"valid", "smtp.example.com:587", "station@example.com", []string{"me@example.com"}, true},
+		{"no port", "smtp.example.com", "station@example.com", []string{"me@example.com"}, false},
+		{"no from", "smtp.example.com:587", "", []string{"me@example.com"}, false},
+		{"no to", "smtp.example.com:587", "station@example.com", nil, false},
+	} {
+		_, err := NewEmail("mail", tc.server, "", "", tc.from, tc.to)
+		if (err == nil) != tc.valid {
+			t.Errorf("%v: expected valid %v, got %v", tc.name, tc.valid, err)
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	sent := recordMail(t, nil)
+	email, err := NewEmail("mail", "smtp.example.com:587", "station", "secret",
+		"station@example.com", []string{"me@example.com", "you@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := Alert{
+		Rule:      "Freeze",
+		Station:   "roof",
+		Sensor:    "temp",
+		Compare:   "<=",
+		Threshold: 32,
+		Unit:      "F",
+		Value:     30.2,
+		Since:     start,
+		Time:      start.Add(time.Hour),
+	}
+	for _, active := range []bool{true, false} {
+		alert.Active = active
+		if err := email.Notify(alert); err != nil {
+			t.Fatal(err)
+		}
+		mail := <-sent
+
+		if mail.server != "smtp.example.com:587" || mail.from != "station@example.com" || len(mail.to) != 2 {
+			t.Errorf("unexpected mail %+v", mail)
+		}
+		if mail.auth == nil {
+			t.Error("expected to authenticate with the username")
+		}
+		headers, body, found := strings.Cut(mail.msg, "\r\n\r\n")
+		if !found {
+			t.Fatalf("expected headers and a body in %q", mail.msg)
+		}
+		for _, header := range []string{
+			"From: station@example.com",
+			"To: me@example.com, you@example.com",
+			"Subject: [Weather] " + alert.Message(),
+			"Content-Type: text/plain; charset=utf-8",
+		} {
+			if !slices.Contains(strings.Split(headers, "\r\n"), header) {
+				t.Errorf("expected the header %q in %q", header, headers)
+			}
+		}
+		if !strings.Contains(body, "Since: "+start.Format(time.RFC1123)) {
+			t.Errorf("expected when the alert started in %q", body)
+		}
+		if resolved := strings.Contains(body, "Resolved: "); resolved == active {
+			t.Errorf("expected the body of an active %v alert to say whether it resolved: %q", active, body)
+		}
+	}
+}
+
+func TestEmailWithoutAuth(t *testing.T) {
+	sent := recordMail(t, errors.New("connection refused"))
+	email, err := NewEmail("mail", "localhost:25", "", "", "station@example.com", []string{"me@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := email.Notify(Alert{Rule: "Freeze", Since: start, Time: start, Active: true}); err == nil {
+		t.Error("expected the error of the smtp server")
+	}
+	if mail := <-sent; mail.auth != nil {
+		t.Error("expected to send without authenticating")
+	}
+}
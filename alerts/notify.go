@@ -0,0 +1,158 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type Notifier interface {
+	Name() string
+	Notify(alert Alert) error
+}
+
+// Posts alerts as json to a url
+type Webhook struct {
+	name    string
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewWebhook(name string, url string, headers map[string]string) *Webhook {
+	return &Webhook{
+		name:    name,
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+type webhookPayload struct {
+	Alert     string    `json:"alert"`
+	Station   string    `json:"station"`
+	Sensor    string    `json:"sensor"`
+	Status    string    `json:"status"`
+	Value     float64   `json:"value"`
+	Compare   string    `json:"compare"`
+	Threshold float64   `json:"threshold"`
+	Unit      string    `json:"unit"`
+	Since     time.Time `json:"since"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+}
+
+func (self *Webhook) Name() string {
+	return self.name
+}
+
+func (self *Webhook) Notify(alert Alert) error {
+	status := "resolved"
+	if alert.Active {
+		status = "firing"
+	}
+	payload, err := json.Marshal(webhookPayload{
+		Alert:     alert.Rule,
+		Station:   alert.Station,
+		Sensor:    alert.Sensor,
+		Status:    status,
+		Value:     alert.Value,
+		Compare:   alert.Compare,
+		Threshold: alert.Threshold,
+		Unit:      alert.Unit,
+		Since:     alert.Since,
+		Time:      alert.Time,
+		Message:   alert.Message(),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", self.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range self.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook responded with %v", resp.Status)
+	}
+	return nil
+}
+
+// How emails are sent to the smtp server
+var sendMail = smtp.SendMail
+
+// Sends alerts by email through an smtp server
+type Email struct {
+	name     string
+	server   string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// The server is a host:port. Without a username, mail is sent without
+// authenticating.
+func NewEmail(name string, server string, username string, password string, from string, to []string) (*Email, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return nil, fmt.Errorf("Email %v: invalid server %v: %w", name, server, err)
+	}
+	if from == "" || len(to) == 0 {
+		return nil, fmt.Errorf("Email %v: needs a from and to address", name)
+	}
+	return &Email{
+		name:     name,
+		server:   server,
+		username: username,
+		password: password,
+		from:     from,
+		to:       to,
+	}, nil
+}
+
+func (self *Email) Name() string {
+	return self.name
+}
+
+func (self *Email) Notify(alert Alert) error {
+	subject := alert.Message()
+	body := fmt.Sprintf(
+		"%v\r\n\r\nSince: %v\r\n",
+		alert.Message(), alert.Since.Local().Format(time.RFC1123),
+	)
+	if !alert.Active {
+		body += fmt.Sprintf("Resolved: %v\r\n", alert.Time.Local().Format(time.RFC1123))
+	}
+
+	msg := strings.Join([]string{
+		"From: " + self.from,
+		"To: " + strings.Join(self.to, ", "),
+		"Subject: [Weather] " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+
+	var auth smtp.Auth = nil
+	if self.username != "" {
+		host, _, _ := net.SplitHostPort(self.server)
+		auth = smtp.PlainAuth("", self.username, self.password, host)
+	}
+	return sendMail(self.server, auth, self.from, self.to, []byte(msg))
+}
@@ -0,0 +1,127 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/util"
+)
+
+// The listener of clients connected from within the process
+const IN_PROCESS = "in-process"
+
+// Clients from within the process are always allowed, and everyone else needs
+// the username and password if there is one
+type authHook struct {
+	mqtt.HookBase
+	username []byte
+	password []byte
+}
+
+func (self *authHook) ID() string {
+	return "station-auth"
+}
+
+func (self *authHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnectAuthenticate,
+		mqtt.OnACLCheck,
+	}, []byte{b})
+}
+
+func (self *authHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	if cl.Net.Listener == IN_PROCESS || len(self.username) == 0 {
+		return true
+	}
+	username := subtle.ConstantTimeCompare(pk.Connect.Username, self.username)
+	password := subtle.ConstantTimeCompare(pk.Connect.Password, self.password)
+	return username&password == 1
+}
+
+func (self *authHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
+	return true
+}
+
+type Broker struct {
+	server *mqtt.Server
+	// The server side of every in-process connection, which the server
+	// doesn't close by itself
+	conns []net.Conn
+	sync.Mutex
+}
+
+// Start an mqtt broker that stations can publish to
+func Start(conf util.BrokerConfig) (*Broker, error) {
+	server := mqtt.New(&mqtt.Options{
+		Logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+			Level: slog.LevelWarn,
+		})),
+	})
+
+	err := server.AddHook(&authHook{
+		username: []byte(conf.Username),
+		password: []byte(conf.Password),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	listen := conf.Listen
+	if listen == "" {
+		listen = ":1883"
+	}
+	err = server.AddListener(listeners.NewTCP(listeners.Config{
+		ID:      "tcp",
+		Address: listen,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := server.Serve(); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Mqtt broker listening on %v", listen)
+	return &Broker{server: server}, nil
+}
+
+// Dial connects to the broker without going through the network
+func (self *Broker) Dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	self.Lock()
+	self.conns = append(self.conns, server)
+	self.Unlock()
+	go func() {
+		err := self.server.EstablishConnection(IN_PROCESS, server)
+		if err != nil {
+			logrus.Infof("In-process mqtt connection closed: %v", err)
+		}
+		self.Lock()
+		defer self.Unlock()
+		for i, conn := range self.conns {
+			if conn == server {
+				self.conns = append(self.conns[:i], self.conns[i+1:]...)
+				break
+			}
+		}
+	}()
+	return client, nil
+}
+
+func (self *Broker) Close() error {
+	self.Lock()
+	for _, conn := range self.conns {
+		conn.Close()
+	}
+	self.conns = nil
+	self.Unlock()
+	return self.server.Close()
+}
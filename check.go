@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func runCheckConfig(args []string) error {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v check-config [config.toml]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	path := configPath(flags)
+	conf, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	if len(conf.Stations) == 0 {
+		return fmt.Errorf("no stations are configured")
+	}
+	if err := configureAlerts(conf); err != nil {
+		return err
+	}
+	if err := checkUploads(conf, nil); err != nil {
+		return err
+	}
+
+	fmt.Printf("%v is valid\n", path)
+	return nil
+}
@@ -8,6 +8,13 @@ import (
 
 var DB *sql.DB = nil
 
+// Queryable is implemented by both *sql.DB and *sql.Tx
+type Queryable interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 func genStringJoins(table string, properties ...string) string {
 	joins := ""
 	for _, property := range properties {
@@ -20,27 +27,47 @@ func genStringJoins(table string, properties ...string) string {
 	return joins
 }
 
-func getReduced(db *sql.DB) (time.Time, error) {
-	// Get the reduced value from db_info, or the earliest time in condition_entry
-	row := db.QueryRow(`SELECT MAX(time) as time FROM (
-		SELECT reduced as time FROM db_info WHERE ID = 1
+// Get the time that a station has been reduced up to for an interval, or the
+// earliest condition of the station if it hasn't been reduced yet.
+func getProgress(db *sql.DB, station string, interval time.Duration) (time.Time, bool, error) {
+	query := fmt.Sprintf(`SELECT MAX(time) as time FROM (
+		SELECT reduced as time FROM reduce_progress
+			WHERE station_id = (SELECT id FROM station WHERE name = ?) AND interval = ?
 		UNION
-		SELECT MIN(time) FROM condition_entry);`)
-	var val string
+		SELECT MIN(time) FROM condition_entry WHERE %v);`,
+		STATION_FILTER,
+	)
+	row := db.QueryRow(query, station, int64(interval.Seconds()), station)
+	var val sql.NullString
 	err := row.Scan(&val)
 	if err != nil {
-		return time.Time{}, err
+		return time.Time{}, false, err
+	}
+	if !val.Valid {
+		return time.Time{}, false, nil
 	}
 
-	t, err := time.Parse("2006-01-02 15:04:05.999999999Z07:00", val)
+	t, err := parseTime(val.String)
 	if err != nil {
-		return time.Time{}, err
+		return time.Time{}, false, err
 	}
 
-	return t, nil
+	return t, true, nil
 }
 
-func setReduced(db *sql.DB, t time.Time) error {
-	_, err := db.Exec(`UPDATE db_info SET reduced = ? WHERE ID = 1;`, t)
+// Parse a time that sqlite returns as text, such as from MIN(time)
+func parseTime(value string) (time.Time, error) {
+	return time.Parse("2006-01-02 15:04:05.999999999Z07:00", value)
+}
+
+func setProgress(db *sql.DB, station string, interval time.Duration, t time.Time) error {
+	id, err := GetOrInsertStation(db, station)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`INSERT OR REPLACE INTO reduce_progress (station_id, interval, reduced) VALUES (?, ?, ?);`,
+		id, int64(interval.Seconds()), t,
+	)
 	return err
 }
@@ -2,25 +2,36 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 )
 
 type Condition struct {
 	Id      int
+	Station string
 	Time    time.Time
 	Sensors map[string]float64
 }
 
-func NewCondition(time time.Time) Condition {
+func NewCondition(station string, time time.Time) Condition {
 	return Condition{
+		Station: station,
 		Time:    time,
 		Sensors: make(map[string]float64),
 	}
 }
 
-func (self *Condition) InsertDb(db *sql.DB) error {
+// The condition has no sensors, such as when every reading was dropped
+var ErrEmptyCondition = errors.New("Condition has no sensors")
+
+func (self *Condition) InsertDb(db Queryable) error {
+	if len(self.Sensors) == 0 {
+		return ErrEmptyCondition
+	}
+
 	string_list := []string{}
 	for key := range self.Sensors {
 		string_list = append(string_list, key)
@@ -31,8 +42,13 @@ func (self *Condition) InsertDb(db *sql.DB) error {
 		return err
 	}
 
-	query := `INSERT INTO condition_entry (time) VALUES (?);`
-	result, err := db.Exec(query, self.Time)
+	station, err := GetOrInsertStation(db, self.Station)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO condition_entry (station_id, time) VALUES (?, ?);`
+	result, err := db.Exec(query, station, self.Time)
 	if err != nil {
 		return err
 	}
@@ -57,8 +73,11 @@ func (self *Condition) InsertDb(db *sql.DB) error {
 		strings.Join(entries, ",\n"),
 	)
 	_, err = db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return updateRecords(db, station, *self)
 }
 
 func fetchSensorsFromEntry(db *sql.DB, id int) (map[string]float64, error) {
@@ -90,15 +109,19 @@ func fetchSensorsFromEntry(db *sql.DB, id int) (map[string]float64, error) {
 	return sensors, nil
 }
 
+// Selects the name of the station that a condition_entry belongs to
+const selectStation = "(SELECT name FROM station WHERE station.id = condition_entry.station_id)"
+
 func FetchCondition(db *sql.DB, condition string, arg ...any) (Condition, error) {
 	query := fmt.Sprintf(
-		`SELECT condition_entry.id, time FROM condition_entry %v LIMIT 1;`,
-		condition,
+		`SELECT condition_entry.id, %v, time FROM condition_entry %v LIMIT 1;`,
+		selectStation, condition,
 	)
 	row := db.QueryRow(query, arg...)
 	var id int
+	var station sql.NullString
 	var time time.Time
-	err := row.Scan(&id, &time)
+	err := row.Scan(&id, &station, &time)
 	if err != nil {
 		return Condition{}, err
 	}
@@ -110,6 +133,7 @@ func FetchCondition(db *sql.DB, condition string, arg ...any) (Condition, error)
 
 	return Condition{
 		Id:      id,
+		Station: station.String,
 		Time:    time,
 		Sensors: sensors,
 	}, nil
@@ -117,8 +141,8 @@ func FetchCondition(db *sql.DB, condition string, arg ...any) (Condition, error)
 
 func FetchConditions(db *sql.DB, condition string, args ...any) ([]Condition, error) {
 	query := fmt.Sprintf(
-		`SELECT id, time FROM condition_entry %v;`,
-		condition,
+		`SELECT id, %v, time FROM condition_entry %v;`,
+		selectStation, condition,
 	)
 
 	rows, err := db.Query(query, args...)
@@ -129,8 +153,9 @@ func FetchConditions(db *sql.DB, condition string, args ...any) ([]Condition, er
 	entries := []Condition{}
 	for rows.Next() {
 		var id int
+		var station sql.NullString
 		var time time.Time
-		err := rows.Scan(&id, &time)
+		err := rows.Scan(&id, &station, &time)
 		if err != nil {
 			return nil, err
 		}
@@ -142,6 +167,7 @@ func FetchConditions(db *sql.DB, condition string, args ...any) ([]Condition, er
 
 		entries = append(entries, Condition{
 			Id:      id,
+			Station: station.String,
 			Time:    time,
 			Sensors: sensors,
 		})
@@ -150,8 +176,8 @@ func FetchConditions(db *sql.DB, condition string, args ...any) ([]Condition, er
 	return entries, nil
 }
 
-func FetchLatestCondition(db *sql.DB) (Condition, error) {
-	return FetchCondition(db, "ORDER BY time DESC")
+func FetchLatestCondition(db *sql.DB, station string) (Condition, error) {
+	return FetchCondition(db, fmt.Sprintf("WHERE %v ORDER BY time DESC", STATION_FILTER), station)
 }
 
 type Pair struct {
@@ -160,35 +186,58 @@ type Pair struct {
 }
 type AveragingFunc func([]Pair) float64
 
-func AverageConditions(
-	conditions []Condition,
-	new_time time.Time,
-	average func(string) AveragingFunc) Condition {
+// AverageConditions combines conditions into one following the rule of each
+// sensor. Sensors that keep extremes also get -min/-max sensors, which take
+// into account the extremes of conditions that have already been reduced.
+func AverageConditions(conditions []Condition, new_time time.Time) Condition {
 	sensors := make(map[string][]Pair)
+	readings := make(map[time.Time]Condition)
 
 	for _, condition := range conditions {
+		readings[condition.Time] = condition
 		for name, value := range condition.Sensors {
-			pairs, exists := sensors[name]
-			if !exists {
-				pairs = []Pair{}
-			}
-			pairs = append(pairs, Pair{
+			sensors[name] = append(sensors[name], Pair{
 				Time:  condition.Time,
 				Value: value,
 			})
-
-			sensors[name] = pairs
 		}
 	}
 
 	averaged := Condition{
+		Station: conditions[0].Station,
 		Time:    new_time,
 		Sensors: make(map[string]float64),
 	}
 
 	for name, pairs := range sensors {
-		averager := average(name)
-		averaged.Sensors[name] = averager(pairs)
+		averaged.Sensors[name] = GetAggregator(name)(pairs)
+	}
+
+	for name, pairs := range sensors {
+		rule := GetSensorRule(name)
+
+		if rule.Aggregate == AGG_MAX_WITH {
+			reading := readings[pairs[maxIndex(pairs)].Time]
+			if companion, exists := reading.Sensors[rule.Companion]; exists {
+				averaged.Sensors[rule.Companion] = companion
+			}
+		}
+
+		for _, extreme := range rule.Extremes {
+			key := name + "-" + extreme
+			value := minimum(pairs)
+			if extreme == "max" {
+				value = maximum(pairs)
+			}
+			if existing, exists := averaged.Sensors[key]; exists {
+				if extreme == "max" {
+					value = math.Max(value, existing)
+				} else {
+					value = math.Min(value, existing)
+				}
+			}
+			averaged.Sensors[key] = value
+		}
 	}
 
 	return averaged
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const FORMAT_CSV = "csv"
+const FORMAT_NDJSON = "ndjson"
+
+// StreamConditions calls fn for every condition of a station between begin and
+// end in chronological order. An empty station streams every station. Rows
+// are read one at a time so that exporting years of data doesn't need to hold
+// every condition in memory.
+func StreamConditions(
+	db Queryable,
+	station string,
+	begin time.Time,
+	end time.Time,
+	fn func(Condition) error) error {
+
+	filter := ""
+	args := []any{begin, end}
+	if station != "" {
+		filter = "AND condition_entry." + STATION_FILTER
+		args = append(args, station)
+	}
+
+	query := fmt.Sprintf(
+		`SELECT condition_entry.id, %v, condition_entry.time, name.value, sensor_value.value
+		FROM condition_entry
+		JOIN sensor_value ON sensor_value.entry_id = condition_entry.id
+		%v
+		WHERE condition_entry.time BETWEEN ? AND ? %v
+		ORDER BY condition_entry.time, condition_entry.id;`,
+		selectStation, genStringJoins("sensor_value", "name"), filter,
+	)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var current *Condition = nil
+	for rows.Next() {
+		var id int
+		var station sql.NullString
+		var t time.Time
+		var name string
+		var value float64
+		if err := rows.Scan(&id, &station, &t, &name, &value); err != nil {
+			return err
+		}
+
+		if current != nil && current.Id != id {
+			if err := fn(*current); err != nil {
+				return err
+			}
+			current = nil
+		}
+		if current == nil {
+			condition := NewCondition(station.String, t)
+			condition.Id = id
+			current = &condition
+		}
+		current.Sensors[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if current != nil {
+		return fn(*current)
+	}
+	return nil
+}
+
+// ExportCsv writes the conditions between begin and end as a csv with one
+// column per sensor.
+func ExportCsv(db *sql.DB, w io.Writer, station string, begin time.Time, end time.Time) error {
+	lookup, err := FetchAllLookupStrings(db)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(lookup))
+	for name := range lookup {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append([]string{"time", "station"}, names...)); err != nil {
+		return err
+	}
+
+	record := make([]string, len(names)+2)
+	err = StreamConditions(db, station, begin, end, func(condition Condition) error {
+		record[0] = condition.Time.Format(time.RFC3339Nano)
+		record[1] = condition.Station
+		for i, name := range names {
+			value, exists := condition.Sensors[name]
+			if exists {
+				record[i+2] = strconv.FormatFloat(value, 'f', -1, 64)
+			} else {
+				record[i+2] = ""
+			}
+		}
+		return writer.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+type ndjsonCondition struct {
+	Time    time.Time          `json:"time"`
+	Station string             `json:"station"`
+	Sensors map[string]float64 `json:"sensors"`
+}
+
+// ExportNdjson writes the conditions between begin and end as one json object
+// per line.
+func ExportNdjson(db *sql.DB, w io.Writer, station string, begin time.Time, end time.Time) error {
+	encoder := json.NewEncoder(w)
+	return StreamConditions(db, station, begin, end, func(condition Condition) error {
+		return encoder.Encode(ndjsonCondition{
+			Time:    condition.Time,
+			Station: condition.Station,
+			Sensors: condition.Sensors,
+		})
+	})
+}
+
+func Export(
+	db *sql.DB,
+	w io.Writer,
+	format string,
+	station string,
+	begin time.Time,
+	end time.Time) error {
+
+	switch format {
+	case FORMAT_CSV:
+		return ExportCsv(db, w, station, begin, end)
+	case FORMAT_NDJSON:
+		return ExportNdjson(db, w, station, begin, end)
+	}
+	return fmt.Errorf("Unknown export format %v", format)
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type conditionKey struct {
+	Station string
+	Time    int64
+}
+
+func newConditionKey(station string, t time.Time) conditionKey {
+	return conditionKey{Station: station, Time: t.Unix()}
+}
+
+// Get the times of every condition near the given range. Times are compared
+// by their unix time, since the same instant may have been stored with a
+// different timezone.
+func fetchConditionTimes(db Queryable, begin time.Time, end time.Time) (map[conditionKey]bool, error) {
+	query := fmt.Sprintf(
+		`SELECT %v, time FROM condition_entry WHERE time BETWEEN ? AND ?;`,
+		selectStation,
+	)
+	rows, err := db.Query(query, begin.Add(-time.Hour*24), end.Add(time.Hour*24))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := make(map[conditionKey]bool)
+	for rows.Next() {
+		var station sql.NullString
+		var t time.Time
+		if err := rows.Scan(&station, &t); err != nil {
+			return nil, err
+		}
+		times[newConditionKey(station.String, t)] = true
+	}
+	return times, rows.Err()
+}
+
+// InsertConditions inserts a batch of conditions in a single transaction.
+// Conditions that share a timestamp with an existing condition of the same
+// station are skipped.
+//
+// The number of inserted and skipped conditions are returned.
+func InsertConditions(db *sql.DB, conditions []Condition) (int, int, error) {
+	if len(conditions) == 0 {
+		return 0, 0, nil
+	}
+
+	begin := conditions[0].Time
+	end := conditions[0].Time
+	for _, condition := range conditions {
+		if condition.Time.Before(begin) {
+			begin = condition.Time
+		}
+		if condition.Time.After(end) {
+			end = condition.Time
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	existing, err := fetchConditionTimes(tx, begin, end)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	inserted := 0
+	skipped := 0
+	for _, condition := range conditions {
+		key := newConditionKey(condition.Station, condition.Time)
+		if existing[key] || len(condition.Sensors) == 0 {
+			skipped += 1
+			continue
+		}
+		if err := condition.InsertDb(tx); err != nil {
+			return 0, 0, err
+		}
+		existing[key] = true
+		inserted += 1
+	}
+
+	return inserted, skipped, tx.Commit()
+}
+
+// MarkUnreduced makes sure that the next reduce will cover everything after t
+// for every tier. This is needed when old conditions are added to the database.
+func MarkUnreduced(db *sql.DB, t time.Time) error {
+	_, err := db.Exec(`UPDATE reduce_progress SET reduced = ? WHERE reduced > ?;`, t, t)
+	return err
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInsertConditions(t *testing.T) {
+	db := openDb(t)
+	start := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	insertCondition(t, db, "roof", start, map[string]float64{"temp": 20})
+
+	denver := time.FixedZone("MDT", -6*60*60)
+	condition := func(station string, at time.Time) Condition {
+		return Condition{Station: station, Time: at, Sensors: map[string]float64{"temp": 21}}
+	}
+	for _, tc := range []struct {
+		name       string
+		conditions []Condition
+		inserted   int
+		skipped    int
+	}{
+		{"nothing", nil, 0, 0},
+		{"existing", []Condition{condition("roof", start)}, 0, 1},
+		// The same instant stored in another time zone is still a duplicate
+		{"existing in another zone", []Condition{condition("roof", start.In(denver))}, 0, 1},
+		{"another station", []Condition{condition("garden", start)}, 1, 0},
+		{"new", []Condition{
+			condition("roof", start.Add(time.Minute)),
+			condition("roof", start.Add(-time.Minute)),
+		}, 2, 0},
+		{"repeated in the batch", []Condition{
+			condition("roof", start.Add(time.Minute*2)),
+			condition("roof", start.Add(time.Minute*2)),
+		}, 1, 1},
+		{"empty", []Condition{{Station: "roof", Time: start.Add(time.Minute * 3), Sensors: map[string]float64{}}}, 0, 1},
+	} {
+		inserted, skipped, err := InsertConditions(db, tc.conditions)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if inserted != tc.inserted || skipped != tc.skipped {
+			t.Errorf("%v: expected %v inserted and %v skipped, got %v and %v",
+				tc.name, tc.inserted, tc.skipped, inserted, skipped)
+		}
+	}
+
+	if roof := fetchRange(t, db, "roof", start.Add(-time.Hour), start.Add(time.Hour)); len(roof) != 4 {
+		t.Errorf("expected 4 conditions for the roof, got %v", len(roof))
+	}
+	if garden := fetchRange(t, db, "garden", start.Add(-time.Hour), start.Add(time.Hour)); len(garden) != 1 {
+		t.Errorf("expected 1 condition for the garden, got %v", len(garden))
+	}
+}
+
+func TestMarkUnreduced(t *testing.T) {
+	db := openDb(t)
+	start := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	insertCondition(t, db, "roof", start, map[string]float64{"temp": 20})
+	if err := setProgress(db, "roof", time.Hour, start.Add(time.Hour*24)); err != nil {
+		t.Fatal(err)
+	}
+	if err := setProgress(db, "roof", time.Hour*24, start.Add(time.Hour*2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MarkUnreduced(db, start.Add(time.Hour*6)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		interval time.Duration
+		want     time.Time
+	}{
+		{time.Hour, start.Add(time.Hour * 6)},
+		// Progress from before the import is kept
+		{time.Hour * 24, start.Add(time.Hour * 2)},
+	} {
+		progress, exists, err := getProgress(db, "roof", tc.interval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists || !progress.Equal(tc.want) {
+			t.Errorf("expected the %v tier to have reduced up to %v, got %v", tc.interval, tc.want, progress)
+		}
+	}
+}
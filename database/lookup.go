@@ -4,11 +4,12 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 )
 
 const LOOKUP_STRINGS string = "lookup_strings"
 
-func FetchLookupStrings(db *sql.DB, strs []string) (map[string]int, error) {
+func FetchLookupStrings(db Queryable, strs []string) (map[string]int, error) {
 	placeholders := make([]string, len(strs))
 	args := make([]any, len(strs))
 	for i, str := range strs {
@@ -40,7 +41,7 @@ func FetchLookupStrings(db *sql.DB, strs []string) (map[string]int, error) {
 	return strings, nil
 }
 
-func InsertLookupStrings(db *sql.DB, strs []string) error {
+func InsertLookupStrings(db Queryable, strs []string) error {
 	placeholders := make([]string, len(strs))
 	args := make([]any, len(strs))
 	for i, str := range strs {
@@ -57,7 +58,7 @@ func InsertLookupStrings(db *sql.DB, strs []string) error {
 	return err
 }
 
-func GetOrInsertLookupStrings(db *sql.DB, strs []string) (map[string]int, error) {
+func GetOrInsertLookupStrings(db Queryable, strs []string) (map[string]int, error) {
 	found, err := FetchLookupStrings(db, strs)
 	if err != nil {
 		return nil, err
@@ -86,3 +87,79 @@ func GetOrInsertLookupStrings(db *sql.DB, strs []string) (map[string]int, error)
 	}
 	return found, nil
 }
+
+func FetchAllLookupStrings(db *sql.DB) (map[string]int, error) {
+	query := fmt.Sprintf("SELECT * FROM %v;", LOOKUP_STRINGS)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	strings := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		strings[value] = id
+	}
+
+	return strings, nil
+}
+
+// How often a sensor has been read, and when
+type SensorStats struct {
+	Name  string
+	Count int
+	First time.Time
+	Last  time.Time
+}
+
+// FetchSensorStats counts the readings of every sensor along with when it was
+// first and last read. An empty station counts the readings of every station.
+func FetchSensorStats(db *sql.DB, station string) ([]SensorStats, error) {
+	filter := ""
+	args := []any{}
+	if station != "" {
+		filter = "AND entry." + STATION_FILTER
+		args = append(args, station)
+	}
+	query := fmt.Sprintf(
+		`SELECT name.value, COUNT(entry.id), MIN(entry.time), MAX(entry.time)
+		FROM %v name
+		LEFT JOIN sensor_value ON sensor_value.name_id = name.id
+		LEFT JOIN condition_entry entry ON entry.id = sensor_value.entry_id %v
+		GROUP BY name.id
+		ORDER BY name.value;`,
+		LOOKUP_STRINGS, filter,
+	)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []SensorStats{}
+	for rows.Next() {
+		var sensor SensorStats
+		var first, last sql.NullString
+		if err := rows.Scan(&sensor.Name, &sensor.Count, &first, &last); err != nil {
+			return nil, err
+		}
+		if first.Valid {
+			if sensor.First, err = parseTime(first.String); err != nil {
+				return nil, err
+			}
+		}
+		if last.Valid {
+			if sensor.Last, err = parseTime(last.String); err != nil {
+				return nil, err
+			}
+		}
+		stats = append(stats, sensor)
+	}
+	return stats, rows.Err()
+}
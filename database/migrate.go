@@ -23,41 +23,104 @@ func getVersion(db *sql.DB) int {
 	return version
 }
 
-func migrate(file string, version int) func(*sql.DB) (int, error) {
-	return func(db *sql.DB) (int, error) {
-		log.Infof("Migrating to V%d", version)
-		var err error
-
-		f, err := migrationFiles.ReadFile(file)
-		if err != nil {
-			return 0, err
-		}
+type migration struct {
+	file    string
+	version int
+}
 
-		_, err = db.Exec(string(f))
-		if err != nil {
-			return 0, err
-		}
-		return version, nil
+func runMigration(db Queryable, m migration) error {
+	log.Infof("Migrating to V%d", m.version)
+	f, err := migrationFiles.ReadFile(m.file)
+	if err != nil {
+		return err
 	}
+	_, err = db.Exec(string(f))
+	return err
 }
 
-var migrations = map[int]func(*sql.DB) (int, error){
-	0: migrate("migrations/v2.sql", 2),
+const VERSION = 7
+
+// The migration from each version to the next
+var migrations = map[int]migration{
+	0: {"migrations/v2.sql", 2},
+	2: {"migrations/v3.sql", 3},
+	3: {"migrations/v4.sql", 4},
+	4: {"migrations/v5.sql", 5},
+	5: {"migrations/v6.sql", 6},
+	6: {"migrations/v7.sql", 7},
 }
 
-func Migrate(db *sql.DB) error {
-	version := getVersion(db)
-	var err error
+// Version gets the version that the database has been migrated to, which is 0
+// for a new database
+func Version(db *sql.DB) int {
+	return getVersion(db)
+}
 
-	for version != 2 {
-		migrate, exists := migrations[version]
+// Get the migrations that bring a database from a version up to VERSION
+func pendingMigrations(version int) ([]migration, error) {
+	pending := []migration{}
+	for version != VERSION {
+		m, exists := migrations[version]
 		if !exists {
-			return fmt.Errorf("Unknown Version %v", version)
+			return nil, fmt.Errorf("Unknown Version %v", version)
 		}
-		version, err = migrate(db)
-		if err != nil {
+		pending = append(pending, m)
+		version = m.version
+	}
+	return pending, nil
+}
+
+// PendingMigrations lists the versions that Migrate would migrate to
+func PendingMigrations(db *sql.DB) ([]int, error) {
+	pending, err := pendingMigrations(getVersion(db))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int, len(pending))
+	for i, m := range pending {
+		versions[i] = m.version
+	}
+	return versions, nil
+}
+
+// CheckVersion makes sure that the database has been migrated to VERSION
+func CheckVersion(db *sql.DB) error {
+	version := getVersion(db)
+	if version != VERSION {
+		return fmt.Errorf("Database is at V%d, expected V%d", version, VERSION)
+	}
+	return nil
+}
+
+func Migrate(db *sql.DB) error {
+	pending, err := pendingMigrations(getVersion(db))
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		if err := runMigration(db, m); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// TryMigrate runs the pending migrations in a transaction that is rolled back,
+// which checks that they apply without changing the database
+func TryMigrate(db *sql.DB) error {
+	pending, err := pendingMigrations(getVersion(db))
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range pending {
+		if err := runMigration(tx, m); err != nil {
+			return fmt.Errorf("V%d: %w", m.version, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+CREATE TABLE station (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE
+);
+
+ALTER TABLE condition_entry ADD COLUMN station_id INTEGER REFERENCES station(id);
+
+CREATE INDEX condition_entry_station_time ON condition_entry (station_id, time);
+
+UPDATE db_info SET version = 3 WHERE id = 1;
@@ -0,0 +1,8 @@
+CREATE TABLE reduce_progress (
+    station_id INTEGER REFERENCES station(id),
+    interval INTEGER,
+    reduced DATETIME,
+    PRIMARY KEY (station_id, interval)
+);
+
+UPDATE db_info SET version = 4 WHERE id = 1;
@@ -0,0 +1,6 @@
+CREATE TABLE sensor_unit (
+    sensor_id INTEGER PRIMARY KEY REFERENCES lookup_strings(id),
+    unit TEXT NOT NULL
+);
+
+UPDATE db_info SET version = 5 WHERE id = 1;
@@ -0,0 +1,12 @@
+CREATE TABLE record (
+    station_id INTEGER REFERENCES station(id),
+    sensor_id INTEGER REFERENCES lookup_strings(id),
+    period TEXT NOT NULL,
+    start TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    value REAL NOT NULL,
+    time DATETIME NOT NULL,
+    PRIMARY KEY (station_id, sensor_id, period, start, kind)
+);
+
+UPDATE db_info SET version = 6 WHERE id = 1;
@@ -0,0 +1,11 @@
+CREATE TABLE upload_queue (
+    id INTEGER PRIMARY KEY,
+    uploader TEXT NOT NULL,
+    station TEXT NOT NULL,
+    time DATETIME NOT NULL,
+    sensors TEXT NOT NULL
+);
+
+CREATE INDEX upload_queue_uploader ON upload_queue (uploader, time);
+
+UPDATE db_info SET version = 7 WHERE id = 1;
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The periods that records are kept for. Each period is identified by its
+// start in local time, such as 2024-06-21 for a day or 2024-06 for a month.
+const PERIOD_DAY = "day"
+const PERIOD_MONTH = "month"
+const PERIOD_YEAR = "year"
+const PERIOD_ALL = "all"
+
+var Periods = []string{PERIOD_DAY, PERIOD_MONTH, PERIOD_YEAR, PERIOD_ALL}
+
+type Record struct {
+	Sensor string
+	Kind   string
+	Period string
+	Start  string
+	Value  float64
+	Time   time.Time
+}
+
+// Get the start of the period that t is in
+func PeriodStart(period string, t time.Time) string {
+	t = t.Local()
+	switch period {
+	case PERIOD_DAY:
+		return t.Format(time.DateOnly)
+	case PERIOD_MONTH:
+		return t.Format("2006-01")
+	case PERIOD_YEAR:
+		return t.Format("2006")
+	}
+	return ""
+}
+
+// Get the extremes of each sensor of a condition. The -min/-max sensors left by
+// the reducer count towards the extremes of their sensor, so reduced conditions
+// keep the records of the conditions they replaced.
+func recordValues(condition Condition) map[string]map[string]float64 {
+	values := make(map[string]map[string]float64)
+	add := func(sensor string, kind string, value float64) {
+		kinds, exists := values[sensor]
+		if !exists {
+			kinds = make(map[string]float64)
+			values[sensor] = kinds
+		}
+		existing, exists := kinds[kind]
+		if !exists || (kind == "max" && value > existing) || (kind == "min" && value < existing) {
+			kinds[kind] = value
+		}
+	}
+
+	for name, value := range condition.Sensors {
+		for _, kind := range RecordKinds(name) {
+			add(name, kind, value)
+		}
+		for _, kind := range []string{"min", "max"} {
+			base, found := strings.CutSuffix(name, "-"+kind)
+			if found && sensorHasExtreme(GetSensorRule(base), kind) {
+				add(base, kind, value)
+			}
+		}
+	}
+	return values
+}
+
+func updateRecords(db Queryable, station int, condition Condition) error {
+	values := recordValues(condition)
+	if len(values) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	lookup, err := GetOrInsertLookupStrings(db, names)
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range []string{"min", "max"} {
+		entries := []string{}
+		args := []any{}
+		for name, kinds := range values {
+			value, exists := kinds[kind]
+			if !exists {
+				continue
+			}
+			for _, period := range Periods {
+				entries = append(entries, "(?, ?, ?, ?, ?, ?, ?)")
+				args = append(args,
+					station, lookup[name], period, PeriodStart(period, condition.Time),
+					kind, value, condition.Time,
+				)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		compare := ">"
+		if kind == "min" {
+			compare = "<"
+		}
+		query := fmt.Sprintf(
+			`INSERT INTO record (station_id, sensor_id, period, start, kind, value, time)
+			VALUES %v
+			ON CONFLICT (station_id, sensor_id, period, start, kind) DO UPDATE
+			SET value = excluded.value, time = excluded.time
+			WHERE excluded.value %v record.value;`,
+			strings.Join(entries, ",\n"), compare,
+		)
+		if _, err := db.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildRecords recalculates every record from the conditions in the database.
+// Records of conditions that have since been deleted are kept.
+func RebuildRecords(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Everything happens in the transaction, since sqlite won't commit while
+	// the conditions are being read by another connection
+	stations := make(map[string]int)
+	err = StreamConditions(tx, "", time.Time{}, time.Now().AddDate(100, 0, 0), func(condition Condition) error {
+		if condition.Station == "" {
+			return nil
+		}
+		station, exists := stations[condition.Station]
+		if !exists {
+			station, err = GetOrInsertStation(tx, condition.Station)
+			if err != nil {
+				return err
+			}
+			stations[condition.Station] = station
+		}
+		return updateRecords(tx, station, condition)
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EnsureRecords builds the records if there are conditions but no records, which
+// is the case after migrating a database from before records were kept.
+func EnsureRecords(db *sql.DB) error {
+	var missing bool
+	err := db.QueryRow(
+		`SELECT NOT EXISTS (SELECT 1 FROM record) AND EXISTS (SELECT 1 FROM condition_entry);`,
+	).Scan(&missing)
+	if err != nil || !missing {
+		return err
+	}
+	log.Info("Building records")
+	return RebuildRecords(db)
+}
+
+// FetchRecords gets the records of a station for one period
+func FetchRecords(db *sql.DB, station string, period string, start string) ([]Record, error) {
+	query := fmt.Sprintf(
+		`SELECT sensor.value, record.kind, record.value, record.time
+		FROM record
+		%v
+		WHERE record.%v AND record.period = ? AND record.start = ?
+		ORDER BY sensor.value, record.kind;`,
+		genStringJoins("record", "sensor"), STATION_FILTER,
+	)
+	rows, err := db.Query(query, station, period, start)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []Record{}
+	for rows.Next() {
+		record := Record{Period: period, Start: start}
+		if err := rows.Scan(&record.Sensor, &record.Kind, &record.Value, &record.Time); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
@@ -2,182 +2,284 @@ package database
 
 import (
 	"database/sql"
-	"math"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/ttocsneb/station-webapp/metrics"
 )
 
-func value(_ int, val float64) float64 {
-	return val
-}
+func reduceConditionsRange(db *sql.DB, station string, begin time.Time, end time.Time) (int, error) {
+	conditions, err := FetchConditions(
+		db,
+		fmt.Sprintf(`WHERE %v AND time >= ? AND time < ? ORDER BY time`, STATION_FILTER),
+		station, begin, end,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if len(conditions) <= 1 {
+		return len(conditions), nil
+	}
 
-func min_sensor(conditions []Condition, key string) (int, float64) {
-	min_value := conditions[0].Sensors[key]
-	index := 0
-	for i, cond := range conditions {
-		val := cond.Sensors[key]
-		if val < min_value {
-			min_value = val
-			index = i
-		}
+	// Every sensor is aggregated following its rule in Sensors
+	new_condition := AverageConditions(conditions, conditions[len(conditions)-1].Time)
+
+	err = new_condition.InsertDb(db)
+	if err != nil && !errors.Is(err, ErrEmptyCondition) {
+		return 0, err
 	}
-	return index, min_value
-}
 
-func max_sensor(conditions []Condition, key string) (int, float64) {
-	max_value := conditions[0].Sensors[key]
-	index := 0
-	for i, cond := range conditions {
-		val := cond.Sensors[key]
-		if val > max_value {
-			max_value = val
-			index = i
-		}
+	err = DeleteConditions(db, conditions)
+	if err != nil {
+		return 0, err
 	}
-	return index, max_value
+
+	return len(conditions), nil
 }
 
-func average(pairs []Pair) float64 {
-	t0 := pairs[0].Time
+type RetentionTier struct {
+	// Conditions older than After are reduced by this tier
+	After time.Duration
+	// One condition is kept for every Interval. An Interval of 0 deletes the
+	// conditions instead.
+	Interval time.Duration
+}
 
-	time_sum := 0.0
-	value_sum := 0.0
+// Past one week, there should only be one sample per hour.
+var DefaultRetention = []RetentionTier{
+	{After: time.Hour * 24 * 7, Interval: time.Hour},
+}
 
-	for _, pair := range pairs {
-		t := pair.Time.Sub(t0).Abs().Seconds()
-		time_sum += t
-		value_sum += t * pair.Value
-	}
+// The retention policy that ReduceConditions follows
+var Retention = DefaultRetention
 
-	return value_sum / time_sum
-}
+// ValidateRetention sorts the tiers by age and makes sure that each tier is
+// coarser than the one before it.
+func ValidateRetention(tiers []RetentionTier) error {
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].After < tiers[j].After
+	})
 
-func averageAngles(pairs []Pair) float64 {
-	xs := make([]Pair, len(pairs))
-	ys := make([]Pair, len(pairs))
-	for i, pair := range pairs {
-		rad := pair.Value * math.Pi / 180
-		xs[i] = Pair{
-			Time:  pair.Time,
-			Value: math.Cos(rad),
+	for i, tier := range tiers {
+		if tier.After <= 0 {
+			return fmt.Errorf("retention tier %v: after must be positive", i+1)
+		}
+		if tier.Interval < 0 {
+			return fmt.Errorf("retention tier %v: interval must be positive", i+1)
+		}
+		if tier.Interval == 0 && i != len(tiers)-1 {
+			return fmt.Errorf("retention tier %v: deleting must be the last tier", i+1)
 		}
-		ys[i] = Pair{
-			Time:  pair.Time,
-			Value: math.Sin(rad),
+		if i > 0 && tier.Interval != 0 && tier.Interval < tiers[i-1].Interval {
+			return fmt.Errorf(
+				"retention tier %v: interval %v is finer than the previous tier's %v",
+				i+1, tier.Interval, tiers[i-1].Interval,
+			)
+		}
+		if i > 0 && tier.After == tiers[i-1].After {
+			return fmt.Errorf("retention tier %v: after %v is used by another tier", i+1, tier.After)
 		}
 	}
-
-	x := average(xs)
-	y := average(ys)
-	return math.Atan2(y, x)
+	return nil
 }
 
-var averageMap = map[string]AveragingFunc{
-	"winddir":        averageAngles,
-	"winddir-avg2m":  averageAngles,
-	"winddir-avg10m": averageAngles,
-	"windgustdir-2m": averageAngles,
+// Get the start of the bucket that t is in. Buckets of a day or less are
+// aligned to local midnight.
+func bucketStart(t time.Time, interval time.Duration) time.Time {
+	if interval > time.Hour*24 {
+		return t.Truncate(interval)
+	}
+	year, month, day := t.Local().Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	return midnight.Add(t.Sub(midnight) / interval * interval)
 }
 
-func getAverager(name string) AveragingFunc {
-	if name == "winddir" || name == "winddir-avg2m" || name == "winddir-avg10m" ||
-		name == "windgustdir-2m" {
-		return averageAngles
+// Get the time of the first condition of a station in [begin, end)
+func nextConditionTime(db *sql.DB, station string, begin time.Time, end time.Time) (time.Time, bool, error) {
+	query := fmt.Sprintf(
+		`SELECT time FROM condition_entry WHERE %v AND time >= ? AND time < ? ORDER BY time LIMIT 1;`,
+		STATION_FILTER,
+	)
+	var t time.Time
+	err := db.QueryRow(query, station, begin, end).Scan(&t)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
 	}
-	return average
+	return t, true, nil
 }
 
-func reduceConditionsRange(db *sql.DB, begin time.Time, end time.Time) (int, error) {
-	conditions, err := FetchConditions(db, `WHERE time BETWEEN ? AND ? ORDER BY time`, begin, end)
+func reduceTier(db *sql.DB, station string, tier RetentionTier, now time.Time) error {
+	boundary := bucketStart(now.Add(-tier.After), tier.Interval)
+
+	progress, exists, err := getProgress(db, station, tier.Interval)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	if len(conditions) <= 1 {
-		return len(conditions), nil
+	if !exists || !progress.Before(boundary) {
+		return nil
 	}
 
-	// Average out all the fields, then get the min/max of each noteworthy field
-	new_condition := AverageConditions(conditions, conditions[len(conditions)-1].Time, getAverager)
-	new_condition.Sensors["temp-min"] = value(min_sensor(conditions, "temp"))
-	new_condition.Sensors["temp-max"] = value(max_sensor(conditions, "temp"))
-	new_condition.Sensors["dewpoint-min"] = value(min_sensor(conditions, "dewpoint"))
-	new_condition.Sensors["dewpoint-max"] = value(max_sensor(conditions, "dewpoint"))
-	new_condition.Sensors["humidity-min"] = value(min_sensor(conditions, "humidity"))
-	new_condition.Sensors["humidity-max"] = value(max_sensor(conditions, "humidity"))
-	new_condition.Sensors["barom-min"] = value(min_sensor(conditions, "barom"))
-	new_condition.Sensors["barom-max"] = value(max_sensor(conditions, "barom"))
-	new_condition.Sensors["uv-min"] = value(min_sensor(conditions, "uv"))
-	new_condition.Sensors["uv-max"] = value(max_sensor(conditions, "uv"))
-	new_condition.Sensors["dailyrain"] = value(max_sensor(conditions, "dailyrain"))
-	i, val := max_sensor(conditions, "windgustspd-2m")
-	new_condition.Sensors["windgustspd-2m"] = val
-	new_condition.Sensors["windgustdir-2m"] = conditions[i].Sensors["windgustdir-2m"]
+	cursor := bucketStart(progress, tier.Interval)
+	reduced := 0
+	for cursor.Before(boundary) {
+		// Skip past any buckets without conditions
+		next, exists, err := nextConditionTime(db, station, cursor, boundary)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			break
+		}
+		cursor = bucketStart(next, tier.Interval)
+		end := cursor.Add(tier.Interval)
 
-	err = new_condition.InsertDb(db)
-	if err != nil {
-		return 0, err
+		_, err = reduceConditionsRange(db, station, cursor, end)
+		if err != nil {
+			return err
+		}
+		cursor = end
+
+		if stopping.Load() {
+			break
+		}
+
+		// Save the progress every so often so that an interrupted reduce
+		// doesn't need to start over
+		reduced += 1
+		if reduced%100 == 0 {
+			if err := setProgress(db, station, tier.Interval, cursor); err != nil {
+				return err
+			}
+		}
 	}
 
-	err = DeleteConditions(db, conditions)
-	if err != nil {
-		return 0, err
+	if cursor.Before(boundary) {
+		return setProgress(db, station, tier.Interval, cursor)
 	}
+	return setProgress(db, station, tier.Interval, boundary)
+}
 
-	return len(conditions), nil
+func deleteConditionsBefore(db *sql.DB, station string, before time.Time) error {
+	condition := fmt.Sprintf(`WHERE %v AND time < ?`, STATION_FILTER)
+	_, err := db.Exec(
+		fmt.Sprintf(`DELETE FROM sensor_value WHERE entry_id IN (SELECT id FROM condition_entry %v);`, condition),
+		station, before,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf(`DELETE FROM condition_entry %v;`, condition), station, before)
+	return err
 }
 
+var reducing sync.Mutex
+var stopping atomic.Bool
+
+// The unix time of the last reduce
+var lastReduce atomic.Int64
+
+var reduceRuns = metrics.NewCounter("database_reduce_runs_total", "How many times the database was reduced")
+var reduceDuration = metrics.NewHistogram(
+	"database_reduce_duration_seconds", "How long it takes to reduce the database",
+	[]float64{.1, .5, 1, 5, 10, 30, 60, 300},
+)
+
+// ReduceConditions applies the retention policy to every station. Each tier
+// keeps track of how far it has reduced, so the policy can change between
+// runs. If a reduce is already running, this returns immediately.
 func ReduceConditions(db *sql.DB) error {
-	year, month, day := time.Now().Local().Date()
-	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	if !reducing.TryLock() {
+		return nil
+	}
+	defer reducing.Unlock()
 
-	stop_time, err := getReduced(db)
+	now := time.Now()
+	defer func() {
+		reduceRuns.Inc()
+		reduceDuration.Observe(time.Since(now).Seconds())
+	}()
+	stations, err := FetchStations(db)
 	if err != nil {
 		return err
 	}
 
-	// Past one week, there should only be one sample per hour.
-	end_time := midnight.Add(-time.Hour * 24 * 7)
-	new_reduced := end_time
-	for end_time.Compare(stop_time) > 0 {
-		start_time := end_time.Add(-time.Hour)
-		_, err := reduceConditionsRange(db, start_time, end_time)
-		if err != nil {
-			return err
+	tiers := getRetention()
+	for _, station := range stations {
+		for _, tier := range tiers {
+			if stopping.Load() {
+				return nil
+			}
+			if tier.Interval == 0 {
+				err = deleteConditionsBefore(db, station, now.Add(-tier.After))
+			} else {
+				err = reduceTier(db, station, tier, now)
+			}
+			if err != nil {
+				return err
+			}
 		}
-		end_time = start_time
 	}
 
-	err = setReduced(db, new_reduced)
-
-	return err
+	lastReduce.Store(now.Unix())
+	return nil
 }
 
-func IsTimeToReduce(db *sql.DB) (bool, error) {
-	t, err := getReduced(db)
-	if err != nil {
-		return false, err
-	}
-	year, month, day := t.Date()
-	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
-	to_reduce := midnight.Add(time.Hour * 24 * 8)
+// ReduceRange reduces the conditions of a station to one per interval for every
+// bucket that starts between begin and end, regardless of the retention policy.
+// Buckets are aligned to the interval, so the first may start before begin.
+// Returns how many buckets were reduced.
+func ReduceRange(db *sql.DB, station string, begin time.Time, end time.Time, interval time.Duration) (int, error) {
+	reducing.Lock()
+	defer reducing.Unlock()
 
-	now := time.Now().Local()
+	cursor := bucketStart(begin, interval)
+	reduced := 0
+	for cursor.Before(end) {
+		next, exists, err := nextConditionTime(db, station, cursor, end)
+		if err != nil {
+			return reduced, err
+		}
+		if !exists {
+			break
+		}
+		cursor = bucketStart(next, interval)
+		bucket_end := cursor.Add(interval)
 
-	return to_reduce.Before(now), nil
+		count, err := reduceConditionsRange(db, station, cursor, bucket_end)
+		if err != nil {
+			return reduced, err
+		}
+		if count > 1 {
+			reduced += 1
+		}
+		cursor = bucket_end
+	}
+	return reduced, nil
+}
 
+// StopReducing waits for a running reduce to finish the range it is on, and
+// keeps any more from starting
+func StopReducing() {
+	stopping.Store(true)
+	reducing.Lock()
 }
 
-// temp: C
-// dewpoint: C
-// humidity: %
-// barom: hPa
-// dailyrain: in
-// rain-1h: in
-// uv: UV Index
-// windspd: km/h
-// winddir: deg
-// windspd-avg2m: km/h
-// winddir-avg2m: deg
-// windspd-avg10m: km/h
-// winddir-avg10m: deg
-// windgustspd-2m: km/h
-// windgustdir-2m: deg
+// A new bucket is ready to be reduced once the finest interval has passed
+// since the last reduce.
+func IsTimeToReduce(db *sql.DB) (bool, error) {
+	wait := time.Hour
+	for _, tier := range getRetention() {
+		if tier.Interval != 0 && tier.Interval < wait {
+			wait = tier.Interval
+		}
+	}
+
+	return time.Since(time.Unix(lastReduce.Load(), 0)) >= wait, nil
+}
@@ -0,0 +1,322 @@
+package database
+
+import (
+	"database/sql"
+	"maps"
+	"math"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openDb(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", t.TempDir()+"/db.sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Run a test in UTC, since buckets are aligned to local midnight
+func inUTC(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+}
+
+func configure(t *testing.T, retention []RetentionTier) {
+	Configure(retention, DefaultSensors, maps.Clone(DefaultUnits))
+	t.Cleanup(func() { Configure(DefaultRetention, DefaultSensors, maps.Clone(DefaultUnits)) })
+}
+
+func insertCondition(t *testing.T, db *sql.DB, station string, at time.Time, sensors map[string]float64) {
+	t.Helper()
+	condition := NewCondition(station, at)
+	for sensor, value := range sensors {
+		condition.Sensors[sensor] = value
+	}
+	if err := condition.InsertDb(db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fetchRange(t *testing.T, db *sql.DB, station string, begin time.Time, end time.Time) []Condition {
+	t.Helper()
+	conditions, err := FetchConditions(
+		db, `WHERE `+STATION_FILTER+` AND time >= ? AND time < ? ORDER BY time`,
+		station, begin, end,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conditions
+}
+
+func TestValidateRetention(t *testing.T) {
+	day := time.Hour * 24
+	for _, tc := range []struct {
+		name  string
+		tiers []RetentionTier
+		valid bool
+	}{
+		{"default", []RetentionTier{{After: day * 7, Interval: time.Hour}}, true},
+		{"none", nil, true},
+		{"coarser", []RetentionTier{{day * 7, time.Hour}, {day * 30, day}, {day * 365, 0}}, true},
+		{"out of order", []RetentionTier{{day * 365, 0}, {day * 30, day}, {day * 7, time.Hour}}, true},
+		{"same interval", []RetentionTier{{day * 7, time.Hour}, {day * 30, time.Hour}}, true},
+		{"no after", []RetentionTier{{0, time.Hour}}, false},
+		{"negative after", []RetentionTier{{-day, time.Hour}}, false},
+		{"negative interval", []RetentionTier{{day, -time.Hour}}, false},
+		{"delete before reducing", []RetentionTier{{day * 7, 0}, {day * 30, day}}, false},
+		{"finer", []RetentionTier{{day * 7, day}, {day * 30, time.Hour}}, false},
+		{"same after", []RetentionTier{{day * 7, time.Hour}, {day * 7, day}}, false},
+	} {
+		err := ValidateRetention(tc.tiers)
+		if (err == nil) != tc.valid {
+			t.Errorf("%v: expected valid %v, got %v", tc.name, tc.valid, err)
+		}
+	}
+
+	tiers := []RetentionTier{{day * 30, day}, {day * 7, time.Hour}}
+	if err := ValidateRetention(tiers); err != nil {
+		t.Fatal(err)
+	}
+	if tiers[0].After != day*7 {
+		t.Errorf("expected the tiers to be sorted by age, got %v", tiers)
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	loc, err := time.LoadLocation("America/Denver")
+	if err != nil {
+		t.Skipf("time zone America/Denver is not available: %v", err)
+	}
+	local := time.Local
+	time.Local = loc
+	t.Cleanup(func() { time.Local = local })
+
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, loc)
+	}
+	for _, tc := range []struct {
+		t        time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		{at(1, 13, 45), time.Hour, at(1, 13, 0)},
+		{at(1, 13, 0), time.Hour, at(1, 13, 0)},
+		{at(1, 13, 45), time.Minute * 15, at(1, 13, 45)},
+		{at(1, 13, 44), time.Minute * 15, at(1, 13, 30)},
+		{at(1, 13, 45), time.Hour * 6, at(1, 12, 0)},
+		// Days start at local midnight rather than UTC midnight
+		{at(1, 13, 45), time.Hour * 24, at(1, 0, 0)},
+		{at(1, 1, 0), time.Hour * 24, at(1, 0, 0)},
+		{at(1, 23, 59), time.Hour * 24, at(1, 0, 0)},
+		{at(1, 13, 45).UTC(), time.Hour * 24, at(1, 0, 0)},
+	} {
+		if got := bucketStart(tc.t, tc.interval); !got.Equal(tc.want) {
+			t.Errorf("expected %v in buckets of %v to start at %v, got %v", tc.t, tc.interval, tc.want, got)
+		}
+	}
+}
+
+func TestAggregators(t *testing.T) {
+	start := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	pairs := func(readings ...float64) []Pair {
+		// Every other reading is 10 minutes apart, so readings are weighted
+		// unevenly by the average
+		result := []Pair{}
+		at := start
+		for i, value := range readings {
+			result = append(result, Pair{Time: at, Value: value})
+			at = at.Add(time.Minute * time.Duration(10+10*(i%2)))
+		}
+		return result
+	}
+	for _, tc := range []struct {
+		name      string
+		aggregate string
+		pairs     []Pair
+		want      float64
+	}{
+		{"single mean", AGG_MEAN, pairs(4), 4},
+		{"even mean", AGG_MEAN, pairs(0, 10), 5},
+		{"time weighted mean", AGG_MEAN, pairs(0, 10, 20), 350.0 / 30},
+		{"same time mean", AGG_MEAN, []Pair{{start, 1}, {start, 3}}, 2},
+		{"circular mean", AGG_CIRCULAR_MEAN, pairs(80, 100), 90},
+		{"circular mean across north", AGG_CIRCULAR_MEAN, pairs(350, 10), 0},
+		{"circular mean west", AGG_CIRCULAR_MEAN, pairs(260, 280), 270},
+		{"sum", AGG_SUM, pairs(1, 2, 3.5), 6.5},
+		{"max", AGG_MAX, pairs(3, 9, -2), 9},
+		{"min", AGG_MIN, pairs(3, 9, -2), -2},
+		{"last", AGG_LAST, pairs(3, 9, -2), -2},
+		{"max with", AGG_MAX_WITH, pairs(3, 9, -2), 9},
+	} {
+		got := aggregators[tc.aggregate](tc.pairs)
+		diff := got - tc.want
+		if tc.aggregate == AGG_CIRCULAR_MEAN {
+			diff = math.Remainder(diff, 360)
+		}
+		if math.Abs(diff) > 1e-9 {
+			t.Errorf("%v: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestValidateSensors(t *testing.T) {
+	if err := ValidateSensors(DefaultSensors); err != nil {
+		t.Errorf("expected the default sensors to be valid, got %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		rule SensorRule
+	}{
+		{"unknown aggregate", SensorRule{Aggregate: "median"}},
+		{"no companion", SensorRule{Aggregate: AGG_MAX_WITH}},
+		{"unexpected companion", SensorRule{Aggregate: AGG_MAX, Companion: "dir"}},
+		{"own companion", SensorRule{Aggregate: AGG_MAX_WITH, Companion: "gust"}},
+		{"unknown extreme", SensorRule{Aggregate: AGG_MEAN, Extremes: []string{"mean"}}},
+	} {
+		if err := ValidateSensors(map[string]SensorRule{"gust": tc.rule}); err == nil {
+			t.Errorf("%v: expected the rule to be invalid", tc.name)
+		}
+	}
+}
+
+func TestAverageConditions(t *testing.T) {
+	start := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	conditions := []Condition{
+		{Station: "roof", Time: start, Sensors: map[string]float64{
+			"temp": 10, "temp-min": 5, "windgustspd-2m": 20, "windgustdir-2m": 90, "dailyrain": 0.1,
+		}},
+		{Station: "roof", Time: start.Add(time.Minute * 10), Sensors: map[string]float64{
+			"temp": 20, "windgustspd-2m": 40, "windgustdir-2m": 180, "dailyrain": 0.2,
+		}},
+		{Station: "roof", Time: start.Add(time.Minute * 20), Sensors: map[string]float64{
+			"temp": 15, "windgustspd-2m": 30, "windgustdir-2m": 270, "dailyrain": 0.2,
+		}},
+	}
+
+	averaged := AverageConditions(conditions, start.Add(time.Minute*20))
+	if averaged.Station != "roof" || !averaged.Time.Equal(start.Add(time.Minute*20)) {
+		t.Errorf("expected the last time of the station, got %v at %v", averaged.Station, averaged.Time)
+	}
+	for _, tc := range []struct {
+		sensor string
+		want   float64
+	}{
+		{"temp", 16.25},
+		// The minimum of a condition that was already reduced is kept
+		{"temp-min", 5},
+		{"temp-max", 20},
+		{"windgustspd-2m", 40},
+		// The direction of the strongest gust rather than the average
+		{"windgustdir-2m", 180},
+		{"dailyrain", 0.2},
+	} {
+		if got, exists := averaged.Sensors[tc.sensor]; !exists || math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("expected %v to be %v, got %v", tc.sensor, tc.want, got)
+		}
+	}
+	if _, exists := averaged.Sensors["dailyrain-max"]; exists {
+		t.Error("expected no extremes for dailyrain")
+	}
+}
+
+func TestReduceConditions(t *testing.T) {
+	inUTC(t)
+	db := openDb(t)
+	day := time.Hour * 24
+	configure(t, []RetentionTier{
+		{After: day * 7, Interval: time.Hour},
+		{After: day * 9, Interval: 0},
+	})
+
+	// A reading every 20 minutes for the last 10 days
+	base := time.Now().Truncate(time.Hour)
+	for i := 0; i < 10*24*3; i++ {
+		insertCondition(t, db, "roof", base.Add(-day*10).Add(time.Duration(i)*time.Minute*20),
+			map[string]float64{"temp": float64(i)})
+	}
+
+	if err := ReduceConditions(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted := fetchRange(t, db, "roof", base.Add(-day*11), base.Add(-day*9)); len(deleted) != 0 {
+		t.Errorf("expected conditions older than 9 days to be deleted, got %v", len(deleted))
+	}
+	reduced := fetchRange(t, db, "roof", base.Add(-day*9).Add(time.Hour), base.Add(-day*7).Add(-time.Hour))
+	if len(reduced) != 46 {
+		t.Errorf("expected one condition per hour between 7 and 9 days, got %v", len(reduced))
+	}
+	recent := fetchRange(t, db, "roof", base.Add(-day*7).Add(time.Hour), base)
+	if len(recent) != (7*24-1)*3 {
+		t.Errorf("expected the last 7 days to be untouched, got %v conditions", len(recent))
+	}
+
+	// The hour starting 8 days ago has the readings 144, 145, and 146
+	hour := fetchRange(t, db, "roof", base.Add(-day*8), base.Add(-day*8).Add(time.Hour))
+	if len(hour) != 1 {
+		t.Fatalf("expected the hour to be reduced to one condition, got %v", len(hour))
+	}
+	if want := base.Add(-day * 8).Add(time.Minute * 40); !hour[0].Time.Equal(want) {
+		t.Errorf("expected the reduced condition at %v, got %v", want, hour[0].Time)
+	}
+	for sensor, want := range map[string]float64{"temp": 145, "temp-min": 144, "temp-max": 146} {
+		if got := hour[0].Sensors[sensor]; got != want {
+			t.Errorf("expected %v to be %v, got %v", sensor, want, got)
+		}
+	}
+
+	// Reducing again has nothing left to do
+	total := len(fetchRange(t, db, "roof", base.Add(-day*11), base))
+	if err := ReduceConditions(db); err != nil {
+		t.Fatal(err)
+	}
+	if again := len(fetchRange(t, db, "roof", base.Add(-day*11), base)); again != total {
+		t.Errorf("expected the second reduce to keep %v conditions, got %v", total, again)
+	}
+}
+
+func TestReduceRange(t *testing.T) {
+	inUTC(t)
+	db := openDb(t)
+
+	start := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 18; i++ {
+		at := start.Add(time.Duration(i) * time.Minute * 10)
+		insertCondition(t, db, "roof", at, map[string]float64{"temp": 20})
+		insertCondition(t, db, "garden", at, map[string]float64{"temp": 25})
+	}
+
+	// The first bucket starts at noon, before the range
+	reduced, err := ReduceRange(db, "roof", start.Add(time.Minute*30), start.Add(time.Hour*2), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reduced != 2 {
+		t.Errorf("expected 2 buckets to be reduced, got %v", reduced)
+	}
+	if got := len(fetchRange(t, db, "roof", start, start.Add(time.Hour*3))); got != 8 {
+		t.Errorf("expected 2 reduced conditions and 6 untouched conditions, got %v", got)
+	}
+	if got := len(fetchRange(t, db, "garden", start, start.Add(time.Hour*3))); got != 18 {
+		t.Errorf("expected the other station to be untouched, got %v conditions", got)
+	}
+
+	// Buckets that have already been reduced are left alone
+	reduced, err = ReduceRange(db, "roof", start, start.Add(time.Hour*2), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reduced != 0 {
+		t.Errorf("expected no buckets to be reduced again, got %v", reduced)
+	}
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The ways that a sensor can be aggregated when conditions are reduced
+const AGG_MEAN = "mean"
+const AGG_CIRCULAR_MEAN = "circular-mean"
+const AGG_SUM = "sum"
+const AGG_MAX = "max"
+const AGG_MIN = "min"
+const AGG_LAST = "last"
+
+// The maximum is kept, along with the companion sensor from the same reading
+const AGG_MAX_WITH = "max-with"
+
+var aggregators = map[string]AveragingFunc{
+	AGG_MEAN:          average,
+	AGG_CIRCULAR_MEAN: averageAngles,
+	AGG_SUM:           sum,
+	AGG_MAX:           maximum,
+	AGG_MIN:           minimum,
+	AGG_LAST:          last,
+	AGG_MAX_WITH:      maximum,
+}
+
+type SensorRule struct {
+	Aggregate string
+	// The sensor read at the same time as the maximum, for max-with
+	Companion string
+	// The extremes (min and/or max) that are kept as -min/-max sensors
+	Extremes []string
+}
+
+var DefaultSensors = map[string]SensorRule{
+	"temp":           {Aggregate: AGG_MEAN, Extremes: []string{"min", "max"}},
+	"dewpoint":       {Aggregate: AGG_MEAN, Extremes: []string{"min", "max"}},
+	"humidity":       {Aggregate: AGG_MEAN, Extremes: []string{"min", "max"}},
+	"barom":          {Aggregate: AGG_MEAN, Extremes: []string{"min", "max"}},
+	"uv":             {Aggregate: AGG_MEAN, Extremes: []string{"min", "max"}},
+	"dailyrain":      {Aggregate: AGG_MAX},
+	"winddir":        {Aggregate: AGG_CIRCULAR_MEAN},
+	"winddir-avg2m":  {Aggregate: AGG_CIRCULAR_MEAN},
+	"winddir-avg10m": {Aggregate: AGG_CIRCULAR_MEAN},
+	"windgustspd-2m": {Aggregate: AGG_MAX_WITH, Companion: "windgustdir-2m"},
+	"windgustdir-2m": {Aggregate: AGG_CIRCULAR_MEAN},
+	"heatindex":      {Aggregate: AGG_MEAN, Extremes: []string{"max"}},
+	"windchill":      {Aggregate: AGG_MEAN, Extremes: []string{"min"}},
+	"humidex":        {Aggregate: AGG_MEAN, Extremes: []string{"max"}},
+	"apparent-temp":  {Aggregate: AGG_MEAN, Extremes: []string{"min", "max"}},
+}
+
+// The rules that AverageConditions follows
+var Sensors = DefaultSensors
+
+// ValidateSensors makes sure that every rule can be followed.
+func ValidateSensors(sensors map[string]SensorRule) error {
+	names := make([]string, 0, len(sensors))
+	for name := range sensors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rule := sensors[name]
+		if _, exists := aggregators[rule.Aggregate]; !exists {
+			return fmt.Errorf("sensor %v: unknown aggregate %v", name, rule.Aggregate)
+		}
+		if rule.Aggregate == AGG_MAX_WITH && rule.Companion == "" {
+			return fmt.Errorf("sensor %v: %v needs a companion", name, AGG_MAX_WITH)
+		}
+		if rule.Aggregate != AGG_MAX_WITH && rule.Companion != "" {
+			return fmt.Errorf("sensor %v: only %v can have a companion", name, AGG_MAX_WITH)
+		}
+		if rule.Companion == name {
+			return fmt.Errorf("sensor %v: cannot be its own companion", name)
+		}
+		for _, extreme := range rule.Extremes {
+			if extreme != "min" && extreme != "max" {
+				return fmt.Errorf("sensor %v: unknown extreme %v", name, extreme)
+			}
+		}
+	}
+	return nil
+}
+
+// Get the rule of a sensor. The min/max companions written by the reducer
+// keep their extreme, and unknown sensors are averaged.
+func GetSensorRule(sensor string) SensorRule {
+	settingsLock.RLock()
+	rule, exists := Sensors[sensor]
+	settingsLock.RUnlock()
+	if exists {
+		return rule
+	}
+	return DefaultSensorRule(sensor)
+}
+
+// Get the rule of a sensor without any configured sensors
+func DefaultSensorRule(sensor string) SensorRule {
+	if rule, exists := DefaultSensors[sensor]; exists {
+		return rule
+	}
+	if _, found := strings.CutSuffix(sensor, "-min"); found {
+		return SensorRule{Aggregate: AGG_MIN}
+	}
+	if _, found := strings.CutSuffix(sensor, "-max"); found {
+		return SensorRule{Aggregate: AGG_MAX}
+	}
+	return SensorRule{Aggregate: AGG_MEAN}
+}
+
+// Get the kinds of records (min and/or max) that are kept for a sensor. These
+// are its extremes, or the max of sensors that are aggregated by their max.
+func RecordKinds(sensor string) []string {
+	rule := GetSensorRule(sensor)
+	if rule.Aggregate == AGG_MAX || rule.Aggregate == AGG_MAX_WITH {
+		if strings.HasSuffix(sensor, "-max") {
+			return nil
+		}
+		if !sensorHasExtreme(rule, "max") {
+			return append([]string{"max"}, rule.Extremes...)
+		}
+	}
+	return rule.Extremes
+}
+
+func sensorHasExtreme(rule SensorRule, extreme string) bool {
+	for _, kind := range rule.Extremes {
+		if kind == extreme {
+			return true
+		}
+	}
+	return false
+}
+
+func GetAggregator(sensor string) AveragingFunc {
+	return aggregators[GetSensorRule(sensor).Aggregate]
+}
+
+// Weight each reading by the time around it, so that irregular readings don't
+// skew the average
+func average(pairs []Pair) float64 {
+	if len(pairs) == 1 {
+		return pairs[0].Value
+	}
+
+	time_sum := 0.0
+	value_sum := 0.0
+	for i, pair := range pairs {
+		t := 0.0
+		if i > 0 {
+			t += pair.Time.Sub(pairs[i-1].Time).Abs().Seconds() / 2
+		}
+		if i < len(pairs)-1 {
+			t += pairs[i+1].Time.Sub(pair.Time).Abs().Seconds() / 2
+		}
+		time_sum += t
+		value_sum += t * pair.Value
+	}
+
+	if time_sum == 0 {
+		for _, pair := range pairs {
+			value_sum += pair.Value
+		}
+		return value_sum / float64(len(pairs))
+	}
+	return value_sum / time_sum
+}
+
+func averageAngles(pairs []Pair) float64 {
+	xs := make([]Pair, len(pairs))
+	ys := make([]Pair, len(pairs))
+	for i, pair := range pairs {
+		rad := pair.Value * math.Pi / 180
+		xs[i] = Pair{
+			Time:  pair.Time,
+			Value: math.Cos(rad),
+		}
+		ys[i] = Pair{
+			Time:  pair.Time,
+			Value: math.Sin(rad),
+		}
+	}
+
+	x := average(xs)
+	y := average(ys)
+	deg := math.Atan2(y, x) * 180 / math.Pi
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
+
+func sum(pairs []Pair) float64 {
+	total := 0.0
+	for _, pair := range pairs {
+		total += pair.Value
+	}
+	return total
+}
+
+func maxIndex(pairs []Pair) int {
+	index := 0
+	for i, pair := range pairs {
+		if pair.Value > pairs[index].Value {
+			index = i
+		}
+	}
+	return index
+}
+
+func maximum(pairs []Pair) float64 {
+	return pairs[maxIndex(pairs)].Value
+}
+
+func minimum(pairs []Pair) float64 {
+	min_value := pairs[0].Value
+	for _, pair := range pairs {
+		min_value = math.Min(min_value, pair.Value)
+	}
+	return min_value
+}
+
+func last(pairs []Pair) float64 {
+	return pairs[len(pairs)-1].Value
+}
@@ -0,0 +1,31 @@
+package database
+
+import "sync"
+
+// Guards the settings that can change while the app is running: Retention,
+// Sensors, and Units
+var settingsLock sync.RWMutex
+
+// Configure replaces the retention policy, the rules of the sensors, and the
+// units they are stored in. Each is expected to have been validated.
+func Configure(retention []RetentionTier, sensors map[string]SensorRule, units map[string]string) {
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	Retention = retention
+	Sensors = sensors
+	Units = units
+}
+
+func getRetention() []RetentionTier {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return Retention
+}
+
+// Get the unit that a sensor is configured to be stored in
+func configuredUnit(sensor string) (string, bool) {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	unit, exists := Units[sensor]
+	return unit, exists
+}
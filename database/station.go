@@ -0,0 +1,58 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Filters condition_entry by the name of a station
+const STATION_FILTER = "station_id = (SELECT id FROM station WHERE name = ?)"
+
+func GetOrInsertStation(db Queryable, name string) (int, error) {
+	row := db.QueryRow(`SELECT id FROM station WHERE name = ?;`, name)
+	var id int
+	err := row.Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	result, err := db.Exec(`INSERT INTO station (name) VALUES (?);`, name)
+	if err != nil {
+		return 0, err
+	}
+	inserted, err := result.LastInsertId()
+	return int(inserted), err
+}
+
+func FetchStations(db Queryable) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM station ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stations := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		stations = append(stations, name)
+	}
+	return stations, rows.Err()
+}
+
+// TagConditions assigns every condition that doesn't belong to a station yet
+// to the given station. Conditions recorded before stations were tracked all
+// came from a single station.
+func TagConditions(db *sql.DB, station string) error {
+	id, err := GetOrInsertStation(db, station)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE condition_entry SET station_id = ? WHERE station_id IS NULL;`, id)
+	return err
+}
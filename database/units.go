@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/units"
+)
+
+// The units that the built-in sensors are stored in
+var DefaultUnits = map[string]string{
+	"temp":           "C",
+	"dewpoint":       "C",
+	"humidity":       "%",
+	"barom":          "hPa",
+	"barom-sea":      "hPa",
+	"dailyrain":      "in",
+	"rain-1h":        "in",
+	"uv":             "UV Index",
+	"windspd":        "km/h",
+	"winddir":        "deg",
+	"windspd-avg2m":  "km/h",
+	"winddir-avg2m":  "deg",
+	"windspd-avg10m": "km/h",
+	"winddir-avg10m": "deg",
+	"windgustspd-2m": "km/h",
+	"windgustdir-2m": "deg",
+	"heatindex":      "C",
+	"windchill":      "C",
+	"apparent-temp":  "C",
+	"cloudbase":      "m",
+}
+
+// The units that every sensor is stored in
+var Units = maps.Clone(DefaultUnits)
+
+// The units of sensors without a canonical unit, as first reported by a station
+var recordedUnits = make(map[string]string)
+var recordedLock sync.RWMutex
+
+// Get the unit that a sensor is stored in. The min/max companions written by
+// the reducer share the unit of their sensor.
+func GetUnit(sensor string) string {
+	if unit, exists := configuredUnit(sensor); exists {
+		return unit
+	}
+	recordedLock.RLock()
+	unit, exists := recordedUnits[sensor]
+	recordedLock.RUnlock()
+	if exists {
+		return unit
+	}
+	for _, suffix := range []string{"-min", "-max"} {
+		if base, found := strings.CutSuffix(sensor, suffix); found {
+			return GetUnit(base)
+		}
+	}
+	return ""
+}
+
+// LoadUnits reads the units that have been recorded for each sensor
+func LoadUnits(db *sql.DB) error {
+	query := fmt.Sprintf(
+		`SELECT %v.value, sensor_unit.unit FROM sensor_unit
+		JOIN %v ON %v.id = sensor_unit.sensor_id;`,
+		LOOKUP_STRINGS, LOOKUP_STRINGS, LOOKUP_STRINGS,
+	)
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	recordedLock.Lock()
+	defer recordedLock.Unlock()
+	for rows.Next() {
+		var sensor string
+		var unit string
+		if err := rows.Scan(&sensor, &unit); err != nil {
+			return err
+		}
+		if configured, exists := configuredUnit(sensor); exists {
+			if configured != unit {
+				log.Warnf("Sensor %v was recorded in %v, but is configured as %v", sensor, unit, configured)
+			}
+			continue
+		}
+		recordedUnits[sensor] = unit
+	}
+	return rows.Err()
+}
+
+// RecordUnit stores the unit of a sensor if it doesn't already have one
+func RecordUnit(db Queryable, sensor string, unit string) error {
+	ids, err := GetOrInsertLookupStrings(db, []string{sensor})
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`INSERT OR IGNORE INTO sensor_unit (sensor_id, unit) VALUES (?, ?);`,
+		ids[sensor], unit,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, exists := configuredUnit(sensor); !exists {
+		recordedLock.Lock()
+		if _, exists := recordedUnits[sensor]; !exists {
+			recordedUnits[sensor] = unit
+		}
+		recordedLock.Unlock()
+	}
+	return nil
+}
+
+// NormalizeReading converts a reading from a station to the unit that its
+// sensor is stored in. A reading without a unit is assumed to already be in
+// that unit.
+//
+// Sensors that have never been seen before keep the unit they are first
+// reported in. Readings that can't be converted are rejected.
+func NormalizeReading(db Queryable, sensor string, value float64, unit string) (float64, error) {
+	stored := GetUnit(sensor)
+	if stored == "" {
+		if unit == "" {
+			return value, nil
+		}
+		if !units.IsKnown(unit) {
+			log.Warnf("Sensor %v is reported in an unknown unit %v, it will not be converted", sensor, unit)
+		}
+		return value, RecordUnit(db, sensor, unit)
+	}
+	if unit == "" || unit == stored {
+		return value, nil
+	}
+
+	converted, err := units.Convert(value, unit, stored)
+	if err != nil {
+		return 0, fmt.Errorf("Sensor %v: %w", sensor, err)
+	}
+	return converted, nil
+}
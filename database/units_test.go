@@ -0,0 +1,59 @@
+package database
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeReading(t *testing.T) {
+	db := openDb(t)
+	for _, tc := range []struct {
+		name   string
+		sensor string
+		value  float64
+		unit   string
+		want   float64
+		valid  bool
+	}{
+		{"stored unit", "temp", 20, "C", 20, true},
+		{"without a unit", "temp", 20, "", 20, true},
+		{"converted", "temp", 68, "F", 20, true},
+		{"pressure", "barom", 29.92, "inHg", 1013.2, true},
+		// Rain is stored in inches
+		{"rain", "dailyrain", 25.4, "mm", 1, true},
+		{"wind", "windspd", 10, "m/s", 36, true},
+		{"wrong kind", "temp", 20, "hPa", 0, false},
+		{"unknown unit", "temp", 20, "widgets", 0, false},
+		{"new sensor", "test-soiltemp", 15, "C", 15, true},
+		{"new sensor converted", "test-soiltemp", 59, "F", 15, true},
+		{"new sensor without a unit", "test-lightning", 3, "", 3, true},
+		{"new sensor in an unknown unit", "test-solar", 500, "W/m^2", 500, true},
+		{"new sensor in the same unknown unit", "test-solar", 600, "W/m^2", 600, true},
+		{"new sensor in another unit", "test-solar", 600, "lux", 0, false},
+	} {
+		got, err := NormalizeReading(db, tc.sensor, tc.value, tc.unit)
+		if (err == nil) != tc.valid {
+			t.Errorf("%v: expected valid %v, got %v", tc.name, tc.valid, err)
+			continue
+		}
+		if tc.valid && math.Abs(got-tc.want) > 0.01 {
+			t.Errorf("%v: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+
+	for _, tc := range []struct {
+		sensor string
+		unit   string
+	}{
+		{"temp", "C"},
+		{"temp-max", "C"},
+		{"test-soiltemp", "C"},
+		{"test-soiltemp-min", "C"},
+		{"test-solar", "W/m^2"},
+		{"test-lightning", ""},
+	} {
+		if unit := GetUnit(tc.sensor); unit != tc.unit {
+			t.Errorf("expected %v to be stored in %q, got %q", tc.sensor, tc.unit, unit)
+		}
+	}
+}
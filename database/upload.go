@@ -0,0 +1,69 @@
+package database
+
+import (
+	"encoding/json"
+)
+
+// A condition waiting to be uploaded to a weather network
+type QueuedUpload struct {
+	Id        int
+	Condition Condition
+}
+
+// QueueUpload adds a condition to the queue of an uploader. Only the newest
+// size conditions are kept, so that a long outage doesn't grow the queue
+// forever.
+func QueueUpload(db Queryable, uploader string, condition Condition, size int) error {
+	sensors, err := json.Marshal(condition.Sensors)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`INSERT INTO upload_queue (uploader, station, time, sensors) VALUES (?, ?, ?, ?);`,
+		uploader, condition.Station, condition.Time, string(sensors),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`DELETE FROM upload_queue WHERE uploader = ? AND id NOT IN (
+			SELECT id FROM upload_queue WHERE uploader = ? ORDER BY time DESC LIMIT ?
+		);`,
+		uploader, uploader, size,
+	)
+	return err
+}
+
+// FetchUploads gets the queue of an uploader, oldest first
+func FetchUploads(db Queryable, uploader string) ([]QueuedUpload, error) {
+	rows, err := db.Query(
+		`SELECT id, station, time, sensors FROM upload_queue
+			WHERE uploader = ? ORDER BY time;`,
+		uploader,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []QueuedUpload{}
+	for rows.Next() {
+		var upload QueuedUpload
+		var sensors string
+		err = rows.Scan(&upload.Id, &upload.Condition.Station, &upload.Condition.Time, &sensors)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(sensors), &upload.Condition.Sensors); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// DeleteUpload removes a condition from the queue once it has been uploaded
+func DeleteUpload(db Queryable, id int) error {
+	_, err := db.Exec(`DELETE FROM upload_queue WHERE id = ?;`, id)
+	return err
+}
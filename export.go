@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/util"
+)
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v export [options] [config.toml]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", database.FORMAT_CSV, "export format (csv or ndjson)")
+	from := flags.String("from", "", "start of the export (RFC3339 or YYYY-MM-DD)")
+	to := flags.String("to", "", "end of the export (RFC3339 or YYYY-MM-DD)")
+	output := flags.String("o", "-", "file to write the export to")
+	station := flags.String("station", "", "only export one station")
+	flags.Parse(args)
+
+	var err error
+	begin := time.Time{}
+	if *from != "" {
+		begin, err = util.ParseTime(*from)
+		if err != nil {
+			return fmt.Errorf("invalid -from: %w", err)
+		}
+	}
+	end := time.Now()
+	if *to != "" {
+		end, err = util.ParseTime(*to)
+		if err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+
+	path := "conf.toml"
+	if flags.NArg() >= 1 {
+		path = flags.Arg(0)
+	}
+	_, db, err := openDatabase(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	buffered := bufio.NewWriter(w)
+	err = database.Export(db, buffered, *format, *station, begin, end)
+	if err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
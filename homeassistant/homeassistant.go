@@ -0,0 +1,267 @@
+package homeassistant
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/station"
+	"github.com/ttocsneb/station-webapp/util"
+)
+
+const DEFAULT_PREFIX = "homeassistant"
+const DEFAULT_TOPIC = "station-webapp"
+
+// How a sensor is shown in Home Assistant
+type entity struct {
+	Name        string
+	DeviceClass string
+	StateClass  string
+}
+
+var entities = map[string]entity{
+	"temp":           {"Temperature", "temperature", "measurement"},
+	"dewpoint":       {"Dew Point", "temperature", "measurement"},
+	"humidity":       {"Humidity", "humidity", "measurement"},
+	"barom":          {"Pressure", "atmospheric_pressure", "measurement"},
+	"barom-sea":      {"Pressure at Sea Level", "atmospheric_pressure", "measurement"},
+	"rain-1h":        {"Rain (Hour)", "precipitation", "measurement"},
+	"dailyrain":      {"Rain (Day)", "precipitation", "total_increasing"},
+	"windspd":        {"Wind Speed", "wind_speed", "measurement"},
+	"windspd-avg2m":  {"Wind Speed (2m)", "wind_speed", "measurement"},
+	"windspd-avg10m": {"Wind Speed (10m)", "wind_speed", "measurement"},
+	"windgustspd-2m": {"Gust Speed", "wind_speed", "measurement"},
+	"winddir":        {"Wind Direction", "", "measurement"},
+	"winddir-avg2m":  {"Wind Direction (2m)", "", "measurement"},
+	"winddir-avg10m": {"Wind Direction (10m)", "", "measurement"},
+	"windgustdir-2m": {"Gust Direction", "", "measurement"},
+	"uv":             {"UV", "", "measurement"},
+	"solarradiation": {"Solar Radiation", "irradiance", "measurement"},
+	"indoortemp":     {"Indoor Temperature", "temperature", "measurement"},
+	"indoorhumidity": {"Indoor Humidity", "humidity", "measurement"},
+	"apparent-temp":  {"Feels Like", "temperature", "measurement"},
+	"heatindex":      {"Heat Index", "temperature", "measurement"},
+	"windchill":      {"Wind Chill", "temperature", "measurement"},
+	"humidex":        {"Humidex", "", "measurement"},
+	"cloudbase":      {"Cloud Base", "distance", "measurement"},
+}
+
+// The units that Home Assistant writes differently
+var haUnits = map[string]string{
+	"C":        "°C",
+	"F":        "°F",
+	"deg":      "°",
+	"knots":    "kn",
+	"UV Index": "UV index",
+}
+
+var invalidId = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// Get an id that can be used in a topic and as a Home Assistant object id
+func topicId(id string) string {
+	return invalidId.ReplaceAllString(id, "_")
+}
+
+type device struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+type availability struct {
+	Topic string `json:"topic"`
+}
+
+type discovery struct {
+	Name             string         `json:"name"`
+	UniqueId         string         `json:"unique_id"`
+	StateTopic       string         `json:"state_topic"`
+	Availability     []availability `json:"availability"`
+	AvailabilityMode string         `json:"availability_mode"`
+	Unit             string         `json:"unit_of_measurement,omitempty"`
+	DeviceClass      string         `json:"device_class,omitempty"`
+	StateClass       string         `json:"state_class,omitempty"`
+	Device           device         `json:"device"`
+}
+
+// Get the topic of a config, which every state is published under
+func getTopic(conf util.HomeAssistantConfig) string {
+	if conf.Topic == "" {
+		return DEFAULT_TOPIC
+	}
+	return conf.Topic
+}
+
+// Availability gets whether the app is connected, which Home Assistant checks
+// along with whether each station is online. The mqtt server marks the app as
+// offline if it stops without disconnecting.
+func Availability(conf util.HomeAssistantConfig) *station.Availability {
+	return &station.Availability{
+		Topic:   fmt.Sprintf("%v/availability", getTopic(conf)),
+		Online:  "online",
+		Offline: "offline",
+	}
+}
+
+// How many updates can wait to be published before new ones are dropped
+const QUEUE_SIZE = 64
+
+// Publishes the sensors of a station to Home Assistant. Each sensor is
+// announced the first time it is seen, and its state is published with every
+// update.
+type Publisher struct {
+	client    *station.Station
+	prefix    string
+	topic     string
+	node      string
+	announced map[string]bool
+	// The publishes waiting for the worker, so that a slow mqtt server doesn't
+	// hold up the updates of the station
+	queue chan func()
+}
+
+func (self *Publisher) publish(topic string, payload []byte) error {
+	token := self.client.Client().Publish(topic, 1, true, payload)
+	if !token.WaitTimeout(time.Second * 10) {
+		return fmt.Errorf("Timed out publishing to %v", topic)
+	}
+	return token.Error()
+}
+
+func (self *Publisher) stateTopic(sensor string) string {
+	return fmt.Sprintf("%v/%v/%v", self.topic, self.node, topicId(sensor))
+}
+
+func (self *Publisher) availabilityTopic() string {
+	return fmt.Sprintf("%v/%v/availability", self.topic, self.node)
+}
+
+func (self *Publisher) announce(sensor string) error {
+	object := topicId(sensor)
+	config := discovery{
+		Name:       sensor,
+		UniqueId:   fmt.Sprintf("station_webapp_%v_%v", self.node, object),
+		StateTopic: self.stateTopic(sensor),
+		Availability: []availability{
+			{Topic: fmt.Sprintf("%v/availability", self.topic)},
+			{Topic: self.availabilityTopic()},
+		},
+		AvailabilityMode: "all",
+		StateClass:       "measurement",
+		Device: device{
+			Identifiers:  []string{fmt.Sprintf("station_webapp_%v", self.node)},
+			Name:         self.client.Name(),
+			Manufacturer: DEFAULT_TOPIC,
+		},
+	}
+	if known, exists := entities[sensor]; exists {
+		config.Name = known.Name
+		config.DeviceClass = known.DeviceClass
+		config.StateClass = known.StateClass
+	}
+	config.Unit = database.GetUnit(sensor)
+	if unit, exists := haUnits[config.Unit]; exists {
+		config.Unit = unit
+	}
+
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	topic := fmt.Sprintf("%v/sensor/%v/%v/config", self.prefix, self.node, object)
+	return self.publish(topic, payload)
+}
+
+func (self *Publisher) update(condition database.Condition) {
+	for sensor, value := range condition.Sensors {
+		if !self.announced[sensor] {
+			if err := self.announce(sensor); err != nil {
+				logrus.Errorf("Could not announce %v to Home Assistant: %v", sensor, err)
+				continue
+			}
+			self.announced[sensor] = true
+		}
+		payload := strconv.FormatFloat(value, 'f', -1, 64)
+		if err := self.publish(self.stateTopic(sensor), []byte(payload)); err != nil {
+			logrus.Errorf("Could not publish %v to Home Assistant: %v", sensor, err)
+		}
+	}
+}
+
+func (self *Publisher) availability(status station.Status) {
+	payload := "offline"
+	if status.Online {
+		payload = "online"
+	}
+	if err := self.publish(self.availabilityTopic(), []byte(payload)); err != nil {
+		logrus.Errorf("Could not publish the availability of %v to Home Assistant: %v", self.client.Id(), err)
+	}
+}
+
+// Hand a publish to the worker, dropping it if the worker is too far behind
+func (self *Publisher) enqueue(work func()) {
+	select {
+	case self.queue <- work:
+	default:
+		logrus.Warnf("Dropping an update of %v to Home Assistant, the mqtt server is too slow", self.client.Id())
+	}
+}
+
+// Publish the sensors of a station to Home Assistant, starting with its latest
+// conditions
+func Publish(db *sql.DB, client *station.Station, conf util.HomeAssistantConfig) {
+	self := &Publisher{
+		client:    client,
+		prefix:    conf.Prefix,
+		topic:     getTopic(conf),
+		node:      topicId(client.Id()),
+		announced: make(map[string]bool),
+		queue:     make(chan func(), QUEUE_SIZE),
+	}
+	if self.prefix == "" {
+		self.prefix = DEFAULT_PREFIX
+	}
+
+	go func() {
+		for work := range self.queue {
+			work()
+		}
+	}()
+
+	updates := client.SubscribeUpdates()
+	statuses := client.SubscribeStatus()
+	go func() {
+		defer close(self.queue)
+
+		self.enqueue(func() {
+			condition, err := database.FetchLatestCondition(db, client.Id())
+			if err == nil {
+				self.update(condition)
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				logrus.Error(err)
+			}
+			self.availability(client.Status())
+		})
+
+		for {
+			select {
+			case condition, ok := <-updates:
+				if !ok {
+					return
+				}
+				self.enqueue(func() { self.update(condition) })
+			case status, ok := <-statuses:
+				if !ok {
+					return
+				}
+				self.enqueue(func() { self.availability(status) })
+			}
+		}
+	}()
+}
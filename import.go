@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/importer"
+)
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v import [options] <archive> [config.toml]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", importer.FORMAT_CSV, "archive format (csv, ndjson, or weewx)")
+	mapping_path := flags.String("map", "", "toml file that maps the archive's columns onto sensors")
+	batch := flags.Int("batch", 500, "number of conditions to insert per transaction")
+	station := flags.String("station", "", "station of conditions without a station column (default first station)")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return fmt.Errorf("missing archive")
+	}
+	if *batch <= 0 {
+		return fmt.Errorf("-batch must be positive")
+	}
+
+	mapping := importer.Mapping{}
+	if *mapping_path != "" {
+		var err error
+		mapping, err = importer.LoadMapping(*mapping_path)
+		if err != nil {
+			return err
+		}
+	}
+
+	path := "conf.toml"
+	if flags.NArg() >= 2 {
+		path = flags.Arg(1)
+	}
+	conf, db, err := openDatabase(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *station != "" {
+		mapping.Station = *station
+	}
+	if mapping.Station == "" {
+		if len(conf.Stations) == 0 {
+			return fmt.Errorf("no stations are configured, use -station")
+		}
+		mapping.Station = conf.Stations[0].Id
+	}
+
+	reader, closer, err := importer.Open(*format, flags.Arg(0), mapping)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	result, err := importer.Import(db, reader, *batch)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Imported %v conditions, skipped %v", result.Inserted, result.Skipped)
+	return nil
+}
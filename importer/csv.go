@@ -0,0 +1,88 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+type CsvReader struct {
+	reader  *csv.Reader
+	mapping Mapping
+	time    int
+	station int
+	columns []Column
+}
+
+func NewCsvReader(r io.Reader, mapping Mapping) (*CsvReader, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	self := &CsvReader{
+		reader:  reader,
+		mapping: mapping,
+		time:    -1,
+		station: -1,
+		columns: make([]Column, len(header)),
+	}
+	for i, name := range header {
+		if name == mapping.timeColumn() {
+			self.time = i
+			continue
+		}
+		if name == "station" {
+			self.station = i
+			continue
+		}
+		self.columns[i] = mapping.column(name)
+	}
+	if self.time == -1 {
+		return nil, fmt.Errorf("Missing time column %v", mapping.timeColumn())
+	}
+
+	return self, nil
+}
+
+func (self *CsvReader) Read() (database.Condition, error) {
+	record, err := self.reader.Read()
+	if err != nil {
+		return database.Condition{}, err
+	}
+	line, _ := self.reader.FieldPos(0)
+
+	t, err := self.mapping.parseTime(record[self.time])
+	if err != nil {
+		return database.Condition{}, fmt.Errorf("line %v: %w", line, err)
+	}
+	station := self.mapping.Station
+	if self.station != -1 && record[self.station] != "" {
+		station = record[self.station]
+	}
+	condition := database.NewCondition(station, t)
+
+	for i, field := range record {
+		column := self.columns[i]
+		if i == self.time || i == self.station || field == "" || column.Sensor == "-" {
+			continue
+		}
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return database.Condition{}, fmt.Errorf("line %v: %w", line, err)
+		}
+		value, err = convert(value, column)
+		if err != nil {
+			return database.Condition{}, fmt.Errorf("line %v: %w", line, err)
+		}
+		condition.Sensors[column.Sensor] = value
+	}
+
+	return condition, nil
+}
@@ -0,0 +1,83 @@
+package importer
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCsvReader(t *testing.T) {
+	archive := strings.Join([]string{
+		"recorded,station,outTemp,barom,battery,wind,humidity",
+		"2024-06-01T12:00:00Z,,68,29.92,4.1,10,50",
+		"2024-06-01T12:05:00Z,garden,77,,4.1,,",
+	}, "\n")
+	reader, err := NewCsvReader(strings.NewReader(archive), Mapping{
+		Station: "roof",
+		Time:    "recorded",
+		Columns: map[string]Column{
+			"outTemp": {Sensor: "temp", Unit: "F"},
+			"barom":   {Unit: "inHg"},
+			"battery": {Sensor: "-"},
+			"wind":    {Sensor: "windspd", Unit: "mph"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noon := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	for _, want := range []struct {
+		station string
+		time    time.Time
+		sensors map[string]float64
+	}{
+		{"roof", noon, map[string]float64{"temp": 20, "barom": 1013.2, "windspd": 16.09, "humidity": 50}},
+		{"garden", noon.Add(time.Minute * 5), map[string]float64{"temp": 25}},
+	} {
+		condition, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if condition.Station != want.station || !condition.Time.Equal(want.time) {
+			t.Errorf("expected %v at %v, got %v at %v", want.station, want.time, condition.Station, condition.Time)
+		}
+		expectSensors(t, condition, want.sensors)
+	}
+	if _, err := reader.Read(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected the end of the archive, got %v", err)
+	}
+}
+
+func TestCsvReaderErrors(t *testing.T) {
+	if _, err := NewCsvReader(strings.NewReader("date,temp\n"), Mapping{}); err == nil {
+		t.Error("expected an error without a time column")
+	}
+	if _, err := NewCsvReader(strings.NewReader(""), Mapping{}); err == nil {
+		t.Error("expected an error without a header")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		archive string
+	}{
+		{"time", "time,temp,pressure\n2024-06-01T12:00:00Z,20,\nyesterday,20,\n"},
+		{"value", "time,temp,pressure\n2024-06-01T12:00:00Z,20,\n2024-06-01T12:05:00Z,warm,\n"},
+		{"unit", "time,temp,pressure\n2024-06-01T12:00:00Z,20,\n2024-06-01T12:05:00Z,,20\n"},
+	} {
+		reader, err := NewCsvReader(strings.NewReader(tc.archive), Mapping{
+			Columns: map[string]Column{"pressure": {Sensor: "temp", Unit: "hPa"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reader.Read(); err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if _, err := reader.Read(); err == nil || !strings.Contains(err.Error(), "line 3") {
+			t.Errorf("%v: expected an error on line 3, got %v", tc.name, err)
+		}
+	}
+}
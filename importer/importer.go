@@ -0,0 +1,198 @@
+package importer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/units"
+)
+
+const FORMAT_CSV = database.FORMAT_CSV
+const FORMAT_NDJSON = database.FORMAT_NDJSON
+const FORMAT_WEEWX = "weewx"
+
+// Reader reads conditions from an archive. io.EOF is returned once there are
+// no more conditions.
+type Reader interface {
+	Read() (database.Condition, error)
+}
+
+// Column maps a column of an archive onto one of our sensors. A sensor of "-"
+// ignores the column, and an empty unit means the column is already in the
+// sensor's unit.
+type Column struct {
+	Sensor string `toml:"sensor"`
+	Unit   string `toml:"unit"`
+}
+
+type Mapping struct {
+	// Station of every condition that doesn't have a station column
+	Station string `toml:"station"`
+	// Name of the column that holds the time
+	Time string `toml:"time"`
+	// Layout of the time column, "unix" for seconds since the epoch. When
+	// empty, RFC3339, "2006-01-02 15:04:05", and unix times are accepted.
+	TimeFormat string            `toml:"time_format"`
+	Columns    map[string]Column `toml:"columns"`
+}
+
+func LoadMapping(path string) (Mapping, error) {
+	mapping := Mapping{}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return mapping, err
+	}
+	err = toml.Unmarshal(contents, &mapping)
+	return mapping, err
+}
+
+func (self Mapping) column(name string) Column {
+	if column, exists := self.Columns[name]; exists {
+		if column.Sensor == "" {
+			column.Sensor = name
+		}
+		return column
+	}
+	return Column{Sensor: name}
+}
+
+func (self Mapping) timeColumn() string {
+	if self.Time == "" {
+		return "time"
+	}
+	return self.Time
+}
+
+func (self Mapping) parseTime(value string) (time.Time, error) {
+	switch self.TimeFormat {
+	case "":
+	case "unix":
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.UnixMilli(int64(seconds * 1000)), nil
+	default:
+		return time.ParseInLocation(self.TimeFormat, value, time.Local)
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateTime, value, time.Local); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.UnixMilli(int64(seconds * 1000)), nil
+	}
+	return time.Time{}, fmt.Errorf("Unable to parse time %v", value)
+}
+
+// Convert a value to the unit that the sensor is stored in
+func convert(value float64, column Column) (float64, error) {
+	canonical := database.GetUnit(column.Sensor)
+	if column.Unit == "" || canonical == "" {
+		return value, nil
+	}
+	return units.Convert(value, column.Unit, canonical)
+}
+
+func Open(format string, path string, mapping Mapping) (Reader, io.Closer, error) {
+	switch format {
+	case FORMAT_CSV:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader, err := NewCsvReader(f, mapping)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return reader, f, nil
+	case FORMAT_NDJSON:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewNdjsonReader(f, mapping), f, nil
+	case FORMAT_WEEWX:
+		archive, err := sql.Open("sqlite3", fmt.Sprintf("file:%v?mode=ro", path))
+		if err != nil {
+			return nil, nil, err
+		}
+		reader, err := NewWeewxReader(archive, mapping)
+		if err != nil {
+			archive.Close()
+			return nil, nil, err
+		}
+		return reader, reader, nil
+	}
+	return nil, nil, fmt.Errorf("Unknown import format %v", format)
+}
+
+type Result struct {
+	Inserted int
+	Skipped  int
+}
+
+// Import every condition from the reader in batches, then reduce the database
+// so that the imported conditions follow the retention policy.
+func Import(db *sql.DB, reader Reader, batch_size int) (Result, error) {
+	result := Result{}
+	batch := make([]database.Condition, 0, batch_size)
+	var earliest time.Time
+
+	flush := func() error {
+		inserted, skipped, err := database.InsertConditions(db, batch)
+		if err != nil {
+			return err
+		}
+		result.Inserted += inserted
+		result.Skipped += skipped
+		logrus.Infof("Imported %v conditions (%v duplicates skipped)", result.Inserted, result.Skipped)
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		condition, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		if earliest.IsZero() || condition.Time.Before(earliest) {
+			earliest = condition.Time
+		}
+
+		batch = append(batch, condition)
+		if len(batch) >= batch_size {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return result, err
+	}
+
+	if result.Inserted == 0 {
+		return result, nil
+	}
+
+	if err := database.MarkUnreduced(db, earliest); err != nil {
+		return result, err
+	}
+	logrus.Info("Reducing database")
+	return result, database.ReduceConditions(db)
+}
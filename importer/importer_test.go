@@ -0,0 +1,192 @@
+package importer
+
+import (
+	"bytes"
+	"database/sql"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+func openDb(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", t.TempDir()+"/db.sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Run a test in UTC, since times without a zone are read in local time
+func inUTC(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+}
+
+// Compare the sensors of a condition with what is expected
+func expectSensors(t *testing.T, condition database.Condition, want map[string]float64) {
+	t.Helper()
+	if len(condition.Sensors) != len(want) {
+		t.Errorf("expected the sensors %v, got %v", want, condition.Sensors)
+	}
+	for sensor, value := range want {
+		if got, exists := condition.Sensors[sensor]; !exists || math.Abs(got-value) > 0.01 {
+			t.Errorf("expected %v to be %v, got %v", sensor, value, got)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	inUTC(t)
+	noon := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		format string
+		value  string
+		want   time.Time
+		valid  bool
+	}{
+		{"", "2024-06-01T12:00:00Z", noon, true},
+		{"", "2024-06-01T06:00:00-06:00", noon, true},
+		{"", "2024-06-01T12:00:00.5Z", noon.Add(time.Millisecond * 500), true},
+		{"", "2024-06-01 12:00:00", noon, true},
+		{"", "1717243200", noon, true},
+		{"", "1717243200.25", noon.Add(time.Millisecond * 250), true},
+		{"", "June 1st", time.Time{}, false},
+		{"unix", "1717243200", noon, true},
+		{"unix", "2024-06-01T12:00:00Z", time.Time{}, false},
+		{"01/02/2006 15:04", "06/01/2024 12:00", noon, true},
+		{"01/02/2006 15:04", "2024-06-01 12:00:00", time.Time{}, false},
+	} {
+		mapping := Mapping{TimeFormat: tc.format}
+		got, err := mapping.parseTime(tc.value)
+		if (err == nil) != tc.valid {
+			t.Errorf("expected %q in %q to be valid %v, got %v", tc.value, tc.format, tc.valid, err)
+			continue
+		}
+		if tc.valid && !got.Equal(tc.want) {
+			t.Errorf("expected %q in %q to be %v, got %v", tc.value, tc.format, tc.want, got)
+		}
+	}
+}
+
+func TestMappingColumn(t *testing.T) {
+	mapping := Mapping{Columns: map[string]Column{
+		"outTemp": {Sensor: "temp", Unit: "F"},
+		"barom":   {Unit: "inHg"},
+		"battery": {Sensor: "-"},
+	}}
+	for _, tc := range []struct {
+		name string
+		want Column
+	}{
+		{"outTemp", Column{Sensor: "temp", Unit: "F"}},
+		{"barom", Column{Sensor: "barom", Unit: "inHg"}},
+		{"battery", Column{Sensor: "-"}},
+		{"humidity", Column{Sensor: "humidity"}},
+	} {
+		if got := mapping.column(tc.name); got != tc.want {
+			t.Errorf("expected the column %v to be %+v, got %+v", tc.name, tc.want, got)
+		}
+	}
+	if mapping.timeColumn() != "time" {
+		t.Errorf("expected the time column to default to time, got %v", mapping.timeColumn())
+	}
+}
+
+func TestImport(t *testing.T) {
+	db := openDb(t)
+	// Recent conditions, so that they are not reduced after the import
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	lines := []string{"time,temp,humidity"}
+	for i := 0; i < 10; i++ {
+		at := start.Add(time.Duration(i) * time.Minute * 5)
+		lines = append(lines, at.UTC().Format(time.RFC3339)+",20,50")
+	}
+	archive := strings.Join(lines, "\n") + "\n"
+	mapping := Mapping{Station: "roof"}
+
+	read := func() Reader {
+		reader, err := NewCsvReader(strings.NewReader(archive), mapping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reader
+	}
+
+	result, err := Import(db, read(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 10 || result.Skipped != 0 {
+		t.Errorf("expected 10 conditions to be imported, got %+v", result)
+	}
+
+	// Importing the same archive again only finds duplicates
+	result, err = Import(db, read(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 0 || result.Skipped != 10 {
+		t.Errorf("expected every condition to be skipped, got %+v", result)
+	}
+
+	// So does importing an export of the database
+	var exported bytes.Buffer
+	if err := database.ExportNdjson(db, &exported, "roof", start, start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Import(db, NewNdjsonReader(&exported, mapping), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 0 || result.Skipped != 10 {
+		t.Errorf("expected every exported condition to be skipped, got %+v", result)
+	}
+
+	conditions, err := database.FetchConditions(db, `WHERE `+database.STATION_FILTER, "roof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conditions) != 10 {
+		t.Errorf("expected 10 conditions, got %v", len(conditions))
+	}
+}
+
+func TestImportReduces(t *testing.T) {
+	inUTC(t)
+	db := openDb(t)
+	// Every minute of an hour more than a week ago
+	lines := []string{"time,temp"}
+	start := time.Now().AddDate(0, 0, -10).Truncate(time.Hour)
+	for i := 0; i < 60; i++ {
+		lines = append(lines, start.Add(time.Duration(i)*time.Minute).Format(time.DateTime)+",20")
+	}
+	reader, err := NewCsvReader(strings.NewReader(strings.Join(lines, "\n")), Mapping{Station: "roof"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Import(db, reader, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 60 {
+		t.Errorf("expected 60 conditions to be imported, got %+v", result)
+	}
+	conditions, err := database.FetchConditions(db, `WHERE `+database.STATION_FILTER, "roof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conditions) != 1 {
+		t.Errorf("expected the hour to be reduced to one condition, got %v", len(conditions))
+	}
+}
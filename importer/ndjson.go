@@ -0,0 +1,73 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+type ndjsonCondition struct {
+	Time    string             `json:"time"`
+	Station string             `json:"station"`
+	Sensors map[string]float64 `json:"sensors"`
+}
+
+type NdjsonReader struct {
+	scanner *bufio.Scanner
+	mapping Mapping
+	line    int
+}
+
+func NewNdjsonReader(r io.Reader, mapping Mapping) *NdjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	return &NdjsonReader{
+		scanner: scanner,
+		mapping: mapping,
+	}
+}
+
+func (self *NdjsonReader) Read() (database.Condition, error) {
+	for self.scanner.Scan() {
+		self.line += 1
+		line := self.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var payload ndjsonCondition
+		if err := json.Unmarshal(line, &payload); err != nil {
+			return database.Condition{}, fmt.Errorf("line %v: %w", self.line, err)
+		}
+		t, err := self.mapping.parseTime(payload.Time)
+		if err != nil {
+			return database.Condition{}, fmt.Errorf("line %v: %w", self.line, err)
+		}
+
+		station := self.mapping.Station
+		if payload.Station != "" {
+			station = payload.Station
+		}
+		condition := database.NewCondition(station, t)
+		for name, value := range payload.Sensors {
+			column := self.mapping.column(name)
+			if column.Sensor == "-" {
+				continue
+			}
+			value, err := convert(value, column)
+			if err != nil {
+				return database.Condition{}, fmt.Errorf("line %v: %w", self.line, err)
+			}
+			condition.Sensors[column.Sensor] = value
+		}
+		return condition, nil
+	}
+
+	if err := self.scanner.Err(); err != nil {
+		return database.Condition{}, err
+	}
+	return database.Condition{}, io.EOF
+}
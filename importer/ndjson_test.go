@@ -0,0 +1,69 @@
+package importer
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNdjsonReader(t *testing.T) {
+	archive := strings.Join([]string{
+		`{"time": "2024-06-01T12:00:00Z", "sensors": {"outTemp": 68, "battery": 4.1, "humidity": 50}}`,
+		``,
+		`{"time": "1717243500", "station": "garden", "sensors": {"outTemp": 77}}`,
+	}, "\n")
+	reader := NewNdjsonReader(strings.NewReader(archive), Mapping{
+		Station: "roof",
+		Columns: map[string]Column{
+			"outTemp": {Sensor: "temp", Unit: "F"},
+			"battery": {Sensor: "-"},
+		},
+	})
+
+	noon := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	for _, want := range []struct {
+		station string
+		time    time.Time
+		sensors map[string]float64
+	}{
+		{"roof", noon, map[string]float64{"temp": 20, "humidity": 50}},
+		{"garden", noon.Add(time.Minute * 5), map[string]float64{"temp": 25}},
+	} {
+		condition, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if condition.Station != want.station || !condition.Time.Equal(want.time) {
+			t.Errorf("expected %v at %v, got %v at %v", want.station, want.time, condition.Station, condition.Time)
+		}
+		expectSensors(t, condition, want.sensors)
+	}
+	if _, err := reader.Read(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected the end of the archive, got %v", err)
+	}
+}
+
+func TestNdjsonReaderErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		line string
+	}{
+		{"json", `{"time": "2024-06-01T12:00:00Z", "sensors": {`},
+		{"time", `{"time": "yesterday", "sensors": {"temp": 20}}`},
+		{"value", `{"time": "2024-06-01T12:00:00Z", "sensors": {"temp": "warm"}}`},
+		{"unit", `{"time": "2024-06-01T12:00:00Z", "sensors": {"pressure": 20}}`},
+	} {
+		archive := `{"time": "2024-06-01T11:00:00Z", "sensors": {"temp": 20}}` + "\n\n" + tc.line
+		reader := NewNdjsonReader(strings.NewReader(archive), Mapping{
+			Columns: map[string]Column{"pressure": {Sensor: "temp", Unit: "hPa"}},
+		})
+		if _, err := reader.Read(); err != nil {
+			t.Fatalf("%v: %v", tc.name, err)
+		}
+		if _, err := reader.Read(); err == nil || !strings.Contains(err.Error(), "line 3") {
+			t.Errorf("%v: expected an error on line 3, got %v", tc.name, err)
+		}
+	}
+}
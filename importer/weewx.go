@@ -0,0 +1,207 @@
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+// WeeWX stores every row of the archive in one of these unit systems, given by
+// the usUnits column
+const weewxUS = 1
+const weewxMetric = 16
+const weewxMetricWX = 17
+
+var weewxUnits = map[int]map[string]string{
+	weewxUS: {
+		"temp":     "F",
+		"pressure": "inHg",
+		"speed":    "mph",
+		"rain":     "in",
+	},
+	weewxMetric: {
+		"temp":     "C",
+		"pressure": "mbar",
+		"speed":    "km/h",
+		"rain":     "cm",
+	},
+	weewxMetricWX: {
+		"temp":     "C",
+		"pressure": "mbar",
+		"speed":    "m/s",
+		"rain":     "mm",
+	},
+}
+
+type weewxColumn struct {
+	Sensor string
+	Group  string
+}
+
+var weewxColumns = map[string]weewxColumn{
+	"outTemp":     {Sensor: "temp", Group: "temp"},
+	"dewpoint":    {Sensor: "dewpoint", Group: "temp"},
+	"outHumidity": {Sensor: "humidity"},
+	"pressure":    {Sensor: "barom", Group: "pressure"},
+	"barometer":   {Sensor: "barom-sea", Group: "pressure"},
+	"windSpeed":   {Sensor: "windspd-avg2m", Group: "speed"},
+	"windDir":     {Sensor: "winddir-avg2m"},
+	"windGust":    {Sensor: "windgustspd-2m", Group: "speed"},
+	"windGustDir": {Sensor: "windgustdir-2m"},
+	"UV":          {Sensor: "uv"},
+}
+
+type weewxRain struct {
+	Time  time.Time
+	Value float64
+}
+
+// WeewxReader reads the archive table of a WeeWX database.
+//
+// WeeWX records the rain that fell during each archive interval, which is
+// accumulated into rain-1h and dailyrain.
+type WeewxReader struct {
+	archive *sql.DB
+	rows    *sql.Rows
+	mapping Mapping
+	names   []string
+	values  []sql.NullFloat64
+
+	rain      []weewxRain
+	daily     float64
+	daily_day time.Time
+}
+
+func NewWeewxReader(archive *sql.DB, mapping Mapping) (*WeewxReader, error) {
+	rows, err := archive.Query(`SELECT * FROM archive ORDER BY dateTime;`)
+	if err != nil {
+		return nil, err
+	}
+	names, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	return &WeewxReader{
+		archive: archive,
+		rows:    rows,
+		mapping: mapping,
+		names:   names,
+		values:  make([]sql.NullFloat64, len(names)),
+	}, nil
+}
+
+func (self *WeewxReader) Close() error {
+	self.rows.Close()
+	return self.archive.Close()
+}
+
+func (self *WeewxReader) column(name string, us_units int) Column {
+	if _, exists := self.mapping.Columns[name]; exists {
+		return self.mapping.column(name)
+	}
+	column, exists := weewxColumns[name]
+	if !exists {
+		return Column{Sensor: "-"}
+	}
+	return Column{
+		Sensor: column.Sensor,
+		Unit:   weewxUnits[us_units][column.Group],
+	}
+}
+
+func (self *WeewxReader) accumulateRain(condition *database.Condition, rain float64) {
+	year, month, day := condition.Time.Local().Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	if !today.Equal(self.daily_day) {
+		self.daily_day = today
+		self.daily = 0
+	}
+	self.daily += rain
+
+	hour_ago := condition.Time.Add(-time.Hour)
+	for len(self.rain) > 0 && !self.rain[0].Time.After(hour_ago) {
+		self.rain = self.rain[1:]
+	}
+	self.rain = append(self.rain, weewxRain{Time: condition.Time, Value: rain})
+
+	hour := 0.0
+	for _, r := range self.rain {
+		hour += r.Value
+	}
+
+	condition.Sensors["rain-1h"] = hour
+	condition.Sensors["dailyrain"] = self.daily
+}
+
+func (self *WeewxReader) Read() (database.Condition, error) {
+	if !self.rows.Next() {
+		if err := self.rows.Err(); err != nil {
+			return database.Condition{}, err
+		}
+		return database.Condition{}, io.EOF
+	}
+
+	dest := make([]any, len(self.values))
+	for i := range self.values {
+		dest[i] = &self.values[i]
+	}
+	if err := self.rows.Scan(dest...); err != nil {
+		return database.Condition{}, err
+	}
+
+	var date_time int64 = -1
+	us_units := weewxUS
+	for i, name := range self.names {
+		switch name {
+		case "dateTime":
+			date_time = int64(self.values[i].Float64)
+		case "usUnits":
+			us_units = int(self.values[i].Float64)
+		}
+	}
+	if date_time == -1 {
+		return database.Condition{}, fmt.Errorf("archive is missing the dateTime column")
+	}
+	if _, exists := weewxUnits[us_units]; !exists {
+		return database.Condition{}, fmt.Errorf("Unknown usUnits %v", us_units)
+	}
+
+	condition := database.NewCondition(self.mapping.Station, time.Unix(date_time, 0))
+	for i, name := range self.names {
+		value := self.values[i]
+		if !value.Valid {
+			continue
+		}
+
+		_, custom := self.mapping.Columns[name]
+		if name == "rain" && !custom {
+			rain, err := convert(value.Float64, Column{
+				Sensor: "rain-1h",
+				Unit:   weewxUnits[us_units]["rain"],
+			})
+			if err != nil {
+				return database.Condition{}, err
+			}
+			self.accumulateRain(&condition, rain)
+			continue
+		}
+
+		column := self.column(name, us_units)
+		if column.Sensor == "-" || name == "dateTime" {
+			continue
+		}
+		converted, err := convert(value.Float64, column)
+		if err != nil {
+			return database.Condition{}, fmt.Errorf("%v: %w", name, err)
+		}
+		condition.Sensors[column.Sensor] = converted
+	}
+
+	return condition, nil
+}
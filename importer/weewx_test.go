@@ -0,0 +1,103 @@
+package importer
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// Create a WeeWX archive with the given rows
+func weewxArchive(t *testing.T, rows ...[]any) string {
+	t.Helper()
+	path := t.TempDir() + "/weewx.sdb"
+	archive, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	_, err = archive.Exec(`CREATE TABLE archive (
+		dateTime INTEGER NOT NULL PRIMARY KEY, usUnits INTEGER NOT NULL, interval INTEGER NOT NULL,
+		outTemp REAL, barometer REAL, pressure REAL, windSpeed REAL, rain REAL, extraTemp1 REAL
+	);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		_, err := archive.Exec(`INSERT INTO archive VALUES (?, ?, 5, ?, ?, ?, ?, ?, ?);`, row...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestWeewxReader(t *testing.T) {
+	inUTC(t)
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	path := weewxArchive(t,
+		[]any{at(1, 23, 30).Unix(), weewxUS, 68, 29.92, nil, 10, 0.1, nil},
+		[]any{at(1, 23, 55).Unix(), weewxMetricWX, 20, nil, 850, 5, 2.54, nil},
+		[]any{at(2, 0, 10).Unix(), weewxMetric, nil, nil, nil, nil, 0.508, nil},
+		[]any{at(2, 0, 40).Unix(), weewxUS, nil, nil, nil, nil, 0, 50},
+	)
+
+	reader, closer, err := Open(FORMAT_WEEWX, path, Mapping{
+		Station: "roof",
+		Columns: map[string]Column{"extraTemp1": {Sensor: "dewpoint", Unit: "F"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	for _, want := range []struct {
+		time    time.Time
+		sensors map[string]float64
+	}{
+		{at(1, 23, 30), map[string]float64{
+			"temp": 20, "barom-sea": 1013.2, "windspd-avg2m": 16.09, "rain-1h": 0.1, "dailyrain": 0.1,
+		}},
+		{at(1, 23, 55), map[string]float64{
+			"temp": 20, "barom": 850, "windspd-avg2m": 18, "rain-1h": 0.2, "dailyrain": 0.2,
+		}},
+		// The daily rain starts over at midnight, but the last hour doesn't
+		{at(2, 0, 10), map[string]float64{"rain-1h": 0.4, "dailyrain": 0.2}},
+		{at(2, 0, 40), map[string]float64{"dewpoint": 10, "rain-1h": 0.3, "dailyrain": 0.2}},
+	} {
+		condition, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if condition.Station != "roof" || !condition.Time.Equal(want.time) {
+			t.Errorf("expected roof at %v, got %v at %v", want.time, condition.Station, condition.Time)
+		}
+		expectSensors(t, condition, want.sensors)
+	}
+	if _, err := reader.Read(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected the end of the archive, got %v", err)
+	}
+}
+
+func TestWeewxReaderUnknownUnits(t *testing.T) {
+	path := weewxArchive(t, []any{time.Now().Unix(), 2, 20, nil, nil, nil, nil, nil})
+	reader, closer, err := Open(FORMAT_WEEWX, path, Mapping{Station: "roof"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	if _, err := reader.Read(); err == nil {
+		t.Error("expected an error for an unknown unit system")
+	}
+}
+
+func TestOpenUnknownFormat(t *testing.T) {
+	if _, _, err := Open("xml", t.TempDir()+"/archive.xml", Mapping{}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
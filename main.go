@@ -2,44 +2,333 @@ package main
 
 import (
 	"database/sql"
+	"flag"
+	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"text/tabwriter"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/ttocsneb/station-webapp/alerts"
 	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/publish"
 	"github.com/ttocsneb/station-webapp/station"
+	"github.com/ttocsneb/station-webapp/units"
 	"github.com/ttocsneb/station-webapp/util"
-	"github.com/ttocsneb/station-webapp/web"
 )
 
-func main() {
+type command struct {
+	run  func([]string) error
+	help string
+}
+
+var commands = map[string]command{
+	"serve":        {runServe, "serve the web app (the default)"},
+	"migrate":      {runMigrate, "migrate the database, or show whether it needs to be"},
+	"reduce":       {runReduce, "reduce the database now"},
+	"check-config": {runCheckConfig, "check the config for errors"},
+	"sensors":      {runSensors, "list every sensor with how often it has been read"},
+	"vacuum":       {runVacuum, "reclaim the space left by deleted conditions"},
+	"export":       {runExport, "export conditions as csv or ndjson"},
+	"import":       {runImport, "import conditions from another station"},
+	"report":       {runReport, "write a NOAA-style monthly or yearly report"},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %v [command] [options] [config.toml]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %v\t%v\n", name, commands[name].help)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nRun '%v <command> -h' for the options of a command.\n", os.Args[0])
+}
+
+// Get the path of the config from the arguments of a command
+func configPath(flags *flag.FlagSet) string {
+	if flags.NArg() >= 1 {
+		return flags.Arg(0)
+	}
+	return "conf.toml"
+}
+
+// The settings of a config that the database package follows
+type databaseSettings struct {
+	retention []database.RetentionTier
+	sensors   map[string]database.SensorRule
+	units     map[string]string
+}
+
+// Read the retention policy and sensors of a config, making sure that they are
+// valid
+func readDatabaseSettings(conf *util.Config) (databaseSettings, error) {
+	settings := databaseSettings{
+		retention: database.DefaultRetention,
+		sensors:   database.DefaultSensors,
+		units:     maps.Clone(database.DefaultUnits),
+	}
+
+	if len(conf.Retention) > 0 {
+		tiers := make([]database.RetentionTier, len(conf.Retention))
+		for i, tier := range conf.Retention {
+			tiers[i] = database.RetentionTier{After: tier.After.Duration}
+			if !tier.Delete {
+				if tier.Interval.Duration <= 0 {
+					return settings, fmt.Errorf("retention tier %v needs an interval or delete", i+1)
+				}
+				tiers[i].Interval = tier.Interval.Duration
+			}
+		}
+		if err := database.ValidateRetention(tiers); err != nil {
+			return settings, err
+		}
+		settings.retention = tiers
+	}
 
-	path := "conf.toml"
-	if len(os.Args) >= 2 {
-		path = os.Args[1]
+	if len(conf.Sensors) > 0 {
+		sensors := maps.Clone(database.DefaultSensors)
+		for name, sensor := range conf.Sensors {
+			rule := database.DefaultSensorRule(name)
+			if sensor.Aggregate != "" {
+				rule = database.SensorRule{Aggregate: sensor.Aggregate}
+			}
+			if sensor.Companion != "" {
+				rule.Companion = sensor.Companion
+			}
+			if sensor.Extremes != nil {
+				rule.Extremes = sensor.Extremes
+			}
+			sensors[name] = rule
+
+			if sensor.Unit != "" {
+				if !units.IsKnown(sensor.Unit) {
+					return settings, fmt.Errorf("sensor %v: unknown unit %v", name, sensor.Unit)
+				}
+				// Built in sensors are already stored in their unit
+				if unit, exists := database.DefaultUnits[name]; exists && unit != sensor.Unit {
+					return settings, fmt.Errorf("sensor %v is always stored in %v", name, unit)
+				}
+				settings.units[name] = sensor.Unit
+			}
+		}
+		if err := database.ValidateSensors(sensors); err != nil {
+			return settings, err
+		}
+		settings.sensors = sensors
 	}
 
+	return settings, nil
+}
+
+// Switch the database package over to the settings
+func (self databaseSettings) apply() {
+	database.Configure(self.retention, self.sensors, self.units)
+}
+
+// Load the config and apply the settings that don't need the database
+func loadConfig(path string) (*util.Config, error) {
 	conf, err := util.LoadConfig(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", conf.Db)
+	settings, err := readDatabaseSettings(conf)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	settings.apply()
+
+	return conf, nil
+}
 
+// Open the database without migrating it
+func connectDatabase(conf *util.Config) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", conf.Db)
+	if err != nil {
+		return nil, err
+	}
 	database.DB = db
+	return db, nil
+}
+
+// Load the config and open the database, migrated and ready to use
+func openDatabase(path string) (*util.Config, *sql.DB, error) {
+	conf, err := loadConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	db, err := connectDatabase(conf)
+	if err != nil {
+		return nil, nil, err
+	}
 	err = database.Migrate(db)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
-	client, err := station.NewStation(db, conf.MqttId, conf.StationId, conf.MqttServer)
+	err = database.LoadUnits(db)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	station.Client = client
 
-	web.Main(db, client)
+	if len(conf.Stations) > 0 {
+		err = database.TagConditions(db, conf.Stations[0].Id)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = database.EnsureRecords(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conf, db, nil
+}
+
+func configureAlerts(conf *util.Config) error {
+	notifiers := []alerts.Notifier{}
+	for _, webhook := range conf.Notify.Webhooks {
+		if webhook.Name == "" || webhook.Url == "" {
+			return fmt.Errorf("webhooks need a name and url")
+		}
+		notifiers = append(notifiers, alerts.NewWebhook(webhook.Name, webhook.Url, webhook.Headers))
+	}
+	for _, email := range conf.Notify.Email {
+		if email.Name == "" {
+			return fmt.Errorf("email notifiers need a name")
+		}
+		notifier, err := alerts.NewEmail(email.Name, email.Server, email.Username, email.Password, email.From, email.To)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	stations := make(map[string]bool)
+	for _, station_conf := range conf.Stations {
+		stations[station_conf.Id] = true
+	}
+
+	rules := make([]alerts.Rule, len(conf.Alerts))
+	for i, alert := range conf.Alerts {
+		if alert.Station != "" && !stations[alert.Station] {
+			return fmt.Errorf("alert %v: unknown station %v", alert.Name, alert.Station)
+		}
+		rules[i] = alerts.Rule{
+			Name:       alert.Name,
+			Station:    alert.Station,
+			Sensor:     alert.Sensor,
+			Compare:    alert.Compare,
+			Threshold:  alert.Threshold,
+			Unit:       alert.Unit,
+			For:        alert.For.Duration,
+			Hysteresis: alert.Hysteresis,
+			Notify:     alert.Notify,
+		}
+		if alert.QuietHours != "" {
+			quiet, err := alerts.ParseQuietHours(alert.QuietHours)
+			if err != nil {
+				return fmt.Errorf("alert %v: %w", alert.Name, err)
+			}
+			rules[i].Quiet = quiet
+		}
+	}
+	return alerts.Configure(rules, notifiers)
+}
+
+// Build the network of an upload, making sure that it is configured correctly
+func uploadNetwork(conf *util.Config, upload util.UploadConfig, name string) (publish.Network, error) {
+	if upload.Station != "" {
+		known := false
+		for _, station_conf := range conf.Stations {
+			known = known || station_conf.Id == upload.Station
+		}
+		if !known {
+			return nil, fmt.Errorf("upload %v: unknown station %v", name, upload.Station)
+		}
+	}
+	if upload.Id == "" {
+		return nil, fmt.Errorf("upload %v needs an id", name)
+	}
+
+	switch upload.Network {
+	case "wunderground":
+		return publish.NewWunderground(upload.Url, upload.Id, upload.Password), nil
+	case "pwsweather":
+		return publish.NewPwsweather(upload.Url, upload.Id, upload.Password), nil
+	case "cwop":
+		if upload.Latitude == 0 && upload.Longitude == 0 {
+			return nil, fmt.Errorf("upload %v needs a latitude and longitude", name)
+		}
+		return publish.NewCwop(upload.Url, upload.Id, upload.Password, upload.Latitude, upload.Longitude), nil
+	}
+	return nil, fmt.Errorf("upload %v: unknown network %v", name, upload.Network)
+}
+
+// Check every upload, calling configure with the network of each
+func checkUploads(conf *util.Config, configure func(upload util.UploadConfig, name string, network publish.Network)) error {
+	names := make(map[string]bool)
+	for _, upload := range conf.Uploads {
+		name := upload.Name
+		if name == "" {
+			name = upload.Network
+		}
+		if names[name] {
+			return fmt.Errorf("upload %v is configured more than once, give each a name", name)
+		}
+		names[name] = true
+
+		network, err := uploadNetwork(conf, upload, name)
+		if err != nil {
+			return err
+		}
+		if configure != nil {
+			configure(upload, name, network)
+		}
+	}
+	return nil
+}
+
+func configureUploads(conf *util.Config, db *sql.DB) error {
+	return checkUploads(conf, func(upload util.UploadConfig, name string, network publish.Network) {
+		client := station.All()[0]
+		if upload.Station != "" {
+			client = station.Find(upload.Station)
+		}
+		uploader := publish.NewUploader(db, name, network, upload.Interval.Duration, upload.Queue, client.Elevation())
+		uploader.Watch(client.SubscribeUpdates())
+	})
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) >= 1 {
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			usage()
+			return
+		}
+	}
+
+	// Without a command, serve so that `station-webapp conf.toml` still works
+	name := "serve"
+	if len(args) >= 1 {
+		if _, exists := commands[args[0]]; exists {
+			name = args[0]
+			args = args[1:]
+		}
+	}
+
+	if err := commands[name].run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", name, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,269 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The buckets of a histogram of durations in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	collect(w io.Writer) error
+}
+
+var registry = struct {
+	collectors []collector
+	sync.Mutex
+}{}
+
+func register(c collector) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.collectors = append(registry.collectors, c)
+}
+
+// Write every metric in the Prometheus text format
+func Write(w io.Writer) error {
+	registry.Lock()
+	collectors := slices.Clone(registry.collectors)
+	registry.Unlock()
+
+	for _, c := range collectors {
+		if err := c.collect(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// The name, help, and labels that every series of a metric shares
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// Get the labels of a series, such as `station="roof",sensor="temp"`
+func (self *family) key(values []string) string {
+	if len(values) != len(self.labels) {
+		panic(fmt.Sprintf("%v has %v labels, got %v", self.name, len(self.labels), len(values)))
+	}
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = fmt.Sprintf(`%v="%v"`, self.labels[i], labelEscaper.Replace(value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (self *family) header(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", self.name, self.help, self.name, self.kind)
+	return err
+}
+
+func writeSample(w io.Writer, name string, key string, value float64) error {
+	if key != "" {
+		name = fmt.Sprintf("%v{%v}", name, key)
+	}
+	_, err := fmt.Fprintf(w, "%v %v\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+	return err
+}
+
+// A metric with a value for each combination of labels
+type valueSet struct {
+	family
+	values map[string]float64
+	sync.Mutex
+}
+
+func (self *valueSet) collect(w io.Writer) error {
+	self.Lock()
+	defer self.Unlock()
+	if err := self.header(w); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(self.values))
+	for key := range self.values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if err := writeSample(w, self.name, key, self.values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *valueSet) add(value float64, labels []string) {
+	key := self.key(labels)
+	self.Lock()
+	defer self.Unlock()
+	self.values[key] += value
+}
+
+// A value that only goes up
+type Counter struct {
+	valueSet
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	self := &Counter{valueSet{
+		family: family{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]float64),
+	}}
+	// Counters without labels start at zero
+	if len(labels) == 0 {
+		self.values[""] = 0
+	}
+	register(self)
+	return self
+}
+
+func (self *Counter) Inc(labels ...string) {
+	self.add(1, labels)
+}
+
+func (self *Counter) Add(value float64, labels ...string) {
+	self.add(value, labels)
+}
+
+// A value that can go up and down
+type Gauge struct {
+	valueSet
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	self := &Gauge{valueSet{
+		family: family{name: name, help: help, kind: "gauge", labels: labels},
+		values: make(map[string]float64),
+	}}
+	register(self)
+	return self
+}
+
+func (self *Gauge) Set(value float64, labels ...string) {
+	key := self.key(labels)
+	self.Lock()
+	defer self.Unlock()
+	self.values[key] = value
+}
+
+func (self *Gauge) Add(value float64, labels ...string) {
+	self.add(value, labels)
+}
+
+// A gauge that is read whenever the metrics are written. The collect function
+// calls set with the value of each series.
+type GaugeFunc struct {
+	family
+	read func(set func(value float64, labels ...string))
+}
+
+func NewGaugeFunc(name string, help string, labels []string, read func(set func(value float64, labels ...string))) *GaugeFunc {
+	self := &GaugeFunc{
+		family: family{name: name, help: help, kind: "gauge", labels: labels},
+		read:   read,
+	}
+	register(self)
+	return self
+}
+
+func (self *GaugeFunc) collect(w io.Writer) error {
+	gauge := valueSet{family: self.family, values: make(map[string]float64)}
+	self.read(func(value float64, labels ...string) {
+		gauge.values[gauge.key(labels)] = value
+	})
+	return gauge.collect(w)
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Counts observations in buckets
+type Histogram struct {
+	family
+	buckets []float64
+	series  map[string]*histogramSeries
+	sync.Mutex
+}
+
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	self := &Histogram{
+		family:  family{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	register(self)
+	return self
+}
+
+func (self *Histogram) Observe(value float64, labels ...string) {
+	key := self.key(labels)
+	self.Lock()
+	defer self.Unlock()
+	series, exists := self.series[key]
+	if !exists {
+		series = &histogramSeries{counts: make([]uint64, len(self.buckets))}
+		self.series[key] = series
+	}
+	for i, bucket := range self.buckets {
+		if value <= bucket {
+			series.counts[i] += 1
+		}
+	}
+	series.sum += value
+	series.count += 1
+}
+
+func (self *Histogram) collect(w io.Writer) error {
+	self.Lock()
+	defer self.Unlock()
+	if err := self.header(w); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(self.series))
+	for key := range self.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	bucketKey := func(key string, le float64) string {
+		bound := fmt.Sprintf(`le="%v"`, strconv.FormatFloat(le, 'g', -1, 64))
+		if key == "" {
+			return bound
+		}
+		return key + "," + bound
+	}
+	for _, key := range keys {
+		series := self.series[key]
+		for i, bucket := range self.buckets {
+			err := writeSample(w, self.name+"_bucket", bucketKey(key, bucket), float64(series.counts[i]))
+			if err != nil {
+				return err
+			}
+		}
+		err := writeSample(w, self.name+"_bucket", bucketKey(key, math.Inf(1)), float64(series.count))
+		if err != nil {
+			return err
+		}
+		if err := writeSample(w, self.name+"_sum", key, series.sum); err != nil {
+			return err
+		}
+		if err := writeSample(w, self.name+"_count", key, float64(series.count)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func collected(t *testing.T, c collector) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := c.collect(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func expect(t *testing.T, got string, lines ...string) {
+	t.Helper()
+	want := strings.Join(lines, "\n") + "\n"
+	if got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	counter := NewCounter("test_messages_total", "Messages received", "station")
+	counter.Inc("roof")
+	counter.Add(2, "roof")
+	counter.Inc("garden")
+
+	expect(t, collected(t, counter),
+		"# HELP test_messages_total Messages received",
+		"# TYPE test_messages_total counter",
+		`test_messages_total{station="garden"} 1`,
+		`test_messages_total{station="roof"} 3`,
+	)
+}
+
+func TestCounterWithoutLabels(t *testing.T) {
+	counter := NewCounter("test_requests_total", "Requests handled")
+
+	expect(t, collected(t, counter),
+		"# HELP test_requests_total Requests handled",
+		"# TYPE test_requests_total counter",
+		"test_requests_total 0",
+	)
+}
+
+func TestGauge(t *testing.T) {
+	gauge := NewGauge("test_sensor_value", "The latest value", "station", "sensor")
+	gauge.Set(21.5, "roof", "temp")
+	gauge.Set(20, "roof", "temp")
+	gauge.Add(-0.25, "roof", "temp")
+	gauge.Set(1013.2, "roof", "barom")
+
+	expect(t, collected(t, gauge),
+		"# HELP test_sensor_value The latest value",
+		"# TYPE test_sensor_value gauge",
+		`test_sensor_value{station="roof",sensor="barom"} 1013.2`,
+		`test_sensor_value{station="roof",sensor="temp"} 19.75`,
+	)
+}
+
+func TestLabelsAreEscaped(t *testing.T) {
+	gauge := NewGauge("test_escaped", "Escaped labels", "station")
+	gauge.Set(1, "a \"quoted\"\\ name\n")
+
+	expect(t, collected(t, gauge),
+		"# HELP test_escaped Escaped labels",
+		"# TYPE test_escaped gauge",
+		`test_escaped{station="a \"quoted\"\\ name\n"} 1`,
+	)
+}
+
+func TestWrongLabels(t *testing.T) {
+	gauge := NewGauge("test_wrong_labels", "Wrong labels", "station")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for the wrong number of labels")
+		}
+	}()
+	gauge.Set(1, "roof", "temp")
+}
+
+func TestGaugeFunc(t *testing.T) {
+	online := map[string]bool{"roof": true, "garden": false}
+	gauge := NewGaugeFunc("test_online", "Whether a station is online", []string{"station"},
+		func(set func(float64, ...string)) {
+			for station, is_online := range online {
+				value := 0.0
+				if is_online {
+					value = 1
+				}
+				set(value, station)
+			}
+		},
+	)
+
+	expect(t, collected(t, gauge),
+		"# HELP test_online Whether a station is online",
+		"# TYPE test_online gauge",
+		`test_online{station="garden"} 0`,
+		`test_online{station="roof"} 1`,
+	)
+
+	// The function is read again every time
+	online["garden"] = true
+	expect(t, collected(t, gauge),
+		"# HELP test_online Whether a station is online",
+		"# TYPE test_online gauge",
+		`test_online{station="garden"} 1`,
+		`test_online{station="roof"} 1`,
+	)
+}
+
+func TestHistogram(t *testing.T) {
+	histogram := NewHistogram("test_duration_seconds", "How long it took", []float64{0.1, 1}, "station")
+	histogram.Observe(0.05, "roof")
+	histogram.Observe(0.5, "roof")
+	histogram.Observe(2, "roof")
+
+	expect(t, collected(t, histogram),
+		"# HELP test_duration_seconds How long it took",
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{station="roof",le="0.1"} 1`,
+		`test_duration_seconds_bucket{station="roof",le="1"} 2`,
+		`test_duration_seconds_bucket{station="roof",le="+Inf"} 3`,
+		`test_duration_seconds_sum{station="roof"} 2.55`,
+		`test_duration_seconds_count{station="roof"} 3`,
+	)
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	histogram := NewHistogram("test_unlabeled_seconds", "How long it took", []float64{1})
+	histogram.Observe(0.5)
+
+	expect(t, collected(t, histogram),
+		"# HELP test_unlabeled_seconds How long it took",
+		"# TYPE test_unlabeled_seconds histogram",
+		`test_unlabeled_seconds_bucket{le="1"} 1`,
+		`test_unlabeled_seconds_bucket{le="+Inf"} 1`,
+		"test_unlabeled_seconds_sum 0.5",
+		"test_unlabeled_seconds_count 1",
+	)
+}
+
+func TestWrite(t *testing.T) {
+	NewCounter("test_written_total", "Written by Write").Inc()
+
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "# TYPE test_written_total counter\ntest_written_total 1\n") {
+		t.Errorf("the registered counter was not written:\n%v", buf.String())
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v migrate [options] [config.toml]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	dry_run := flags.Bool("dry-run", false, "check that the migrations apply without changing the database")
+	status := flags.Bool("status", false, "show the version of the database without migrating it")
+	flags.Parse(args)
+
+	conf, err := loadConfig(configPath(flags))
+	if err != nil {
+		return err
+	}
+	db, err := connectDatabase(conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version := database.Version(db)
+	pending, err := database.PendingMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	if *status {
+		fmt.Printf("Database %v is at V%d of V%d\n", conf.Db, version, database.VERSION)
+		if len(pending) == 0 {
+			fmt.Println("It is up to date")
+		}
+		for _, m := range pending {
+			fmt.Printf("Pending: V%d\n", m)
+		}
+		return nil
+	}
+
+	if len(pending) == 0 {
+		fmt.Printf("Database %v is already at V%d\n", conf.Db, version)
+		return nil
+	}
+
+	if *dry_run {
+		for _, m := range pending {
+			fmt.Printf("Would migrate to V%d\n", m)
+		}
+		if err := database.TryMigrate(db); err != nil {
+			return fmt.Errorf("the migration would fail: %w", err)
+		}
+		fmt.Println("Every migration applies cleanly, nothing was changed")
+		return nil
+	}
+
+	if err := database.Migrate(db); err != nil {
+		return err
+	}
+	fmt.Printf("Migrated %v from V%d to V%d\n", conf.Db, version, database.VERSION)
+	return nil
+}
@@ -0,0 +1,142 @@
+package publish
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+const CWOP_SERVER = "cwop.aprs.net:14580"
+
+// Sends APRS weather reports to the Citizen Weather Observer Program over
+// APRS-IS
+type Cwop struct {
+	server    string
+	callsign  string
+	passcode  string
+	latitude  float64
+	longitude float64
+}
+
+// Create a CWOP uploader. Stations without a ham license log in with a
+// passcode of -1.
+func NewCwop(server string, callsign string, passcode string, latitude float64, longitude float64) *Cwop {
+	if server == "" {
+		server = CWOP_SERVER
+	}
+	if passcode == "" {
+		passcode = "-1"
+	}
+	return &Cwop{
+		server:    server,
+		callsign:  strings.ToUpper(callsign),
+		passcode:  passcode,
+		latitude:  latitude,
+		longitude: longitude,
+	}
+}
+
+// CWOP asks that stations report no more than once every 5 minutes
+func (self *Cwop) Interval() time.Duration {
+	return time.Minute * 5
+}
+
+// Format a coordinate as degrees and decimal minutes (4903.50N)
+func aprsCoordinate(value float64, degrees int, positive string, negative string) string {
+	hemisphere := positive
+	if value < 0 {
+		hemisphere = negative
+		value = -value
+	}
+	whole := math.Floor(value)
+	minutes := (value - whole) * 60
+	// Rounding can carry the minutes over to the next degree
+	if math.Round(minutes*100) >= 6000 {
+		whole += 1
+		minutes = 0
+	}
+	return fmt.Sprintf("%0*d%05.2f%v", degrees, int(whole), minutes, hemisphere)
+}
+
+// Format a reading with a fixed number of digits, or dots if it is missing
+func aprsValue(condition database.Condition, sensor string, unit string, scale float64, digits int) string {
+	value, exists := reading(condition, sensor, unit)
+	if !exists {
+		return strings.Repeat(".", digits)
+	}
+	n := int(math.Round(value * scale))
+	if n < 0 {
+		return fmt.Sprintf("-%0*d", digits-1, min(-n, int(math.Pow10(digits-1))-1))
+	}
+	return fmt.Sprintf("%0*d", digits, min(n, int(math.Pow10(digits))-1))
+}
+
+// Get the APRS weather report of a condition
+func (self *Cwop) packet(condition database.Condition) string {
+	var packet strings.Builder
+	fmt.Fprintf(&packet, "%v>APRS,TCPIP*:@%vz%v/%v_",
+		self.callsign,
+		condition.Time.UTC().Format("021504"),
+		aprsCoordinate(self.latitude, 2, "N", "S"),
+		aprsCoordinate(self.longitude, 3, "E", "W"),
+	)
+	packet.WriteString(aprsValue(condition, "winddir-avg2m", "deg", 1, 3))
+	packet.WriteString("/")
+	packet.WriteString(aprsValue(condition, "windspd-avg2m", "mph", 1, 3))
+	packet.WriteString("g")
+	packet.WriteString(aprsValue(condition, "windgustspd-2m", "mph", 1, 3))
+	packet.WriteString("t")
+	packet.WriteString(aprsValue(condition, "temp", "F", 1, 3))
+	if _, exists := condition.Sensors["rain-1h"]; exists {
+		packet.WriteString("r")
+		packet.WriteString(aprsValue(condition, "rain-1h", "in", 100, 3))
+	}
+	if _, exists := condition.Sensors["dailyrain"]; exists {
+		packet.WriteString("P")
+		packet.WriteString(aprsValue(condition, "dailyrain", "in", 100, 3))
+	}
+	if humidity, exists := reading(condition, "humidity", "%"); exists {
+		// 100% humidity is sent as 00
+		fmt.Fprintf(&packet, "h%02d", int(math.Round(humidity))%100)
+	}
+	if _, exists := condition.Sensors["barom-sea"]; exists {
+		packet.WriteString("b")
+		packet.WriteString(aprsValue(condition, "barom-sea", "hPa", 10, 5))
+	}
+	packet.WriteString(SOFTWARE)
+	return packet.String()
+}
+
+func (self *Cwop) Send(condition database.Condition) error {
+	conn, err := net.DialTimeout("tcp", self.server, time.Second*30)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 30))
+	reader := bufio.NewReader(conn)
+
+	// The server greets with a comment before it accepts the login
+	if _, err := reader.ReadString('\n'); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(conn, "user %v pass %v vers %v\r\n", self.callsign, self.passcode, SOFTWARE)
+	if err != nil {
+		return err
+	}
+	response, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(response, "# logresp") {
+		return fmt.Errorf("Login was rejected: %v", strings.TrimSpace(response))
+	}
+
+	_, err = fmt.Fprintf(conn, "%v\r\n", self.packet(condition))
+	return err
+}
@@ -0,0 +1,159 @@
+package publish
+
+import (
+	"database/sql"
+	"maps"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/units"
+	"github.com/ttocsneb/station-webapp/weather"
+)
+
+// How many conditions are kept for an uploader that can't reach its network
+const DEFAULT_QUEUE = 500
+
+// How long to wait after the first failed upload, which doubles after every
+// failure up to MAX_BACKOFF
+const MIN_BACKOFF = time.Second * 30
+const MAX_BACKOFF = time.Minute * 30
+
+// A weather network that conditions are uploaded to
+type Network interface {
+	// The shortest time between uploads that the network allows
+	Interval() time.Duration
+	Send(condition database.Condition) error
+}
+
+// Uploads the conditions of a station to a network, at most once per interval.
+// Conditions are queued in the database until they are sent, so that they
+// aren't lost while the network can't be reached.
+type Uploader struct {
+	name        string
+	db          *sql.DB
+	network     Network
+	interval    time.Duration
+	queue       int
+	last_queued time.Time
+	wake        chan any
+	// The elevation of the station, to reduce its pressure to sea level
+	elevation *float64
+}
+
+// Create an uploader. The interval is raised to that of the network if it is
+// shorter. Without an elevation, only a pressure at sea level that the station
+// reports is uploaded.
+func NewUploader(
+	db *sql.DB,
+	name string,
+	network Network,
+	interval time.Duration,
+	queue int,
+	elevation *float64) *Uploader {
+
+	if queue <= 0 {
+		queue = DEFAULT_QUEUE
+	}
+	return &Uploader{
+		name:      name,
+		db:        db,
+		network:   network,
+		interval:  max(interval, network.Interval()),
+		queue:     queue,
+		elevation: elevation,
+		wake:      make(chan any, 1),
+	}
+}
+
+func (self *Uploader) Name() string {
+	return self.name
+}
+
+// Watch queues the conditions of a station and uploads them in the background
+func (self *Uploader) Watch(updates chan database.Condition) {
+	go self.run()
+	go func() {
+		for condition := range updates {
+			if condition.Time.Sub(self.last_queued) < self.interval {
+				continue
+			}
+			condition = self.withSeaPressure(condition)
+			err := database.QueueUpload(self.db, self.name, condition, self.queue)
+			if err != nil {
+				logrus.Errorf("Could not queue upload to %v: %v", self.name, err)
+				continue
+			}
+			self.last_queued = condition.Time
+			select {
+			case self.wake <- true:
+			default:
+			}
+		}
+	}()
+}
+
+// Networks are sent the pressure at sea level. Stations that only report the
+// station pressure have it reduced to sea level with their elevation.
+func (self *Uploader) withSeaPressure(condition database.Condition) database.Condition {
+	if _, exists := condition.Sensors["barom-sea"]; exists {
+		return condition
+	}
+	pressure, exists := weather.SeaPressure(condition.Sensors, self.elevation)
+	if !exists {
+		return condition
+	}
+	// The condition is shared with everyone watching the station
+	condition.Sensors = maps.Clone(condition.Sensors)
+	condition.Sensors["barom-sea"] = pressure
+	return condition
+}
+
+func (self *Uploader) run() {
+	backoff := time.Duration(0)
+	var last_sent time.Time
+	for {
+		uploads, err := database.FetchUploads(self.db, self.name)
+		if err != nil {
+			logrus.Errorf("Could not read upload queue of %v: %v", self.name, err)
+		}
+		if len(uploads) == 0 {
+			<-self.wake
+			continue
+		}
+
+		for _, upload := range uploads {
+			time.Sleep(time.Until(last_sent.Add(self.interval)))
+			err := self.network.Send(upload.Condition)
+			last_sent = time.Now()
+			if err != nil {
+				backoff = min(max(backoff*2, MIN_BACKOFF), MAX_BACKOFF)
+				logrus.Warnf("Could not upload to %v, retrying in %v: %v", self.name, backoff, err)
+				time.Sleep(backoff)
+				break
+			}
+			backoff = 0
+			logrus.Infof("Uploaded conditions from %v to %v", upload.Condition.Time.Local(), self.name)
+			if err := database.DeleteUpload(self.db, upload.Id); err != nil {
+				logrus.Errorf("Could not remove upload from the queue of %v: %v", self.name, err)
+			}
+		}
+	}
+}
+
+// Get a sensor of a condition in a unit. An empty unit leaves the value as it
+// is stored.
+func reading(condition database.Condition, sensor string, unit string) (float64, bool) {
+	value, exists := condition.Sensors[sensor]
+	if !exists {
+		return 0, false
+	}
+	if unit == "" {
+		return value, true
+	}
+	value, err := units.Convert(value, database.GetUnit(sensor), unit)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
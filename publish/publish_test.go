@@ -0,0 +1,67 @@
+package publish
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/weather"
+)
+
+func elevation(meters float64) *float64 {
+	return &meters
+}
+
+func TestWithSeaPressure(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		sensors   map[string]float64
+		elevation *float64
+		want      float64
+		exists    bool
+	}{
+		{"reported", map[string]float64{"barom": 858.05, "barom-sea": 1010}, elevation(1400), 1010, true},
+		{"reduced", map[string]float64{"barom": 858.05, "temp": 20}, elevation(1400), weather.SeaLevelPressure(858.05, 1400, 20), true},
+		{"unknown elevation", map[string]float64{"barom": 858.05, "temp": 20}, nil, 0, false},
+		{"no pressure", map[string]float64{"temp": 20}, elevation(1400), 0, false},
+	} {
+		uploader := &Uploader{elevation: tc.elevation}
+		condition := database.Condition{Station: "roof", Time: time.Now(), Sensors: tc.sensors}
+		_, reported := tc.sensors["barom-sea"]
+
+		got := uploader.withSeaPressure(condition)
+		pressure, exists := got.Sensors["barom-sea"]
+		if exists != tc.exists || pressure != tc.want {
+			t.Errorf("%v: expected %v (%v), got %v (%v)", tc.name, tc.want, tc.exists, pressure, exists)
+		}
+		if _, changed := tc.sensors["barom-sea"]; changed != reported {
+			t.Errorf("%v: the condition of the station was changed", tc.name)
+		}
+	}
+}
+
+func TestSendSeaPressure(t *testing.T) {
+	uploader := &Uploader{elevation: elevation(1400)}
+	condition := uploader.withSeaPressure(database.Condition{
+		Station: "roof",
+		Time:    time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC),
+		Sensors: map[string]float64{"barom": 858.05, "temp": 20},
+	})
+
+	query := NewWunderground("", "KTEST1", "key").query(condition)
+	if got := query.Get("baromin"); got != "29.75" {
+		t.Errorf("expected baromin to be 29.75, got %v", got)
+	}
+	if got := query.Get("tempf"); got != "68.0" {
+		t.Errorf("expected tempf to be 68.0, got %v", got)
+	}
+
+	packet := NewCwop("", "cw0001", "", 40.7128, -74.006).packet(condition)
+	if !strings.Contains(packet, "t068") || !strings.Contains(packet, "b10076") {
+		t.Errorf("expected the temperature and pressure at sea level in %v", packet)
+	}
+	if !strings.HasPrefix(packet, "CW0001>APRS,TCPIP*:@011200z4042.77N/07400.36W_") {
+		t.Errorf("unexpected packet %v", packet)
+	}
+}
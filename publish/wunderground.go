@@ -0,0 +1,133 @@
+package publish
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+const WUNDERGROUND_URL = "https://weatherstation.wunderground.com/weatherstation/updateweatherstation.php"
+const PWSWEATHER_URL = "https://pwsupdate.pwsweather.com/api/v1/submitwx"
+
+const SOFTWARE = "station-webapp"
+
+// A parameter of the Weather Underground protocol, and the sensor it is read
+// from
+type wundergroundParam struct {
+	Name      string
+	Sensor    string
+	Unit      string
+	Precision int
+}
+
+var wundergroundParams = []wundergroundParam{
+	{"tempf", "temp", "F", 1},
+	{"dewptf", "dewpoint", "F", 1},
+	{"humidity", "humidity", "%", 0},
+	{"baromin", "barom-sea", "inHg", 2},
+	{"windspeedmph", "windspd", "mph", 1},
+	{"winddir", "winddir", "deg", 0},
+	{"windspdmph_avg2m", "windspd-avg2m", "mph", 1},
+	{"winddir_avg2m", "winddir-avg2m", "deg", 0},
+	{"windspdmph_avg10m", "windspd-avg10m", "mph", 1},
+	{"winddir_avg10m", "winddir-avg10m", "deg", 0},
+	{"windgustmph", "windgustspd-2m", "mph", 1},
+	{"windgustdir", "windgustdir-2m", "deg", 0},
+	{"rainin", "rain-1h", "in", 2},
+	{"dailyrainin", "dailyrain", "in", 2},
+	{"UV", "uv", "", 1},
+	{"solarradiation", "solarradiation", "", 0},
+	{"indoortempf", "indoortemp", "F", 1},
+	{"indoorhumidity", "indoorhumidity", "%", 0},
+}
+
+// Uploads with the Weather Underground protocol, which PWSweather shares
+type Wunderground struct {
+	url      string
+	id       string
+	password string
+	interval time.Duration
+	// The response has to contain expect when it isn't empty
+	expect string
+	client *http.Client
+}
+
+func NewWunderground(endpoint string, id string, password string) *Wunderground {
+	if endpoint == "" {
+		endpoint = WUNDERGROUND_URL
+	}
+	return &Wunderground{
+		url:      endpoint,
+		id:       id,
+		password: password,
+		interval: time.Minute,
+		expect:   "success",
+		client:   &http.Client{Timeout: time.Second * 30},
+	}
+}
+
+func NewPwsweather(endpoint string, id string, password string) *Wunderground {
+	if endpoint == "" {
+		endpoint = PWSWEATHER_URL
+	}
+	return &Wunderground{
+		url:      endpoint,
+		id:       id,
+		password: password,
+		interval: time.Minute,
+		client:   &http.Client{Timeout: time.Second * 30},
+	}
+}
+
+func (self *Wunderground) Interval() time.Duration {
+	return self.interval
+}
+
+// Get the query of an upload
+func (self *Wunderground) query(condition database.Condition) url.Values {
+	query := url.Values{}
+	query.Set("ID", self.id)
+	query.Set("PASSWORD", self.password)
+	query.Set("action", "updateraw")
+	query.Set("dateutc", condition.Time.UTC().Format(time.DateTime))
+	query.Set("softwaretype", SOFTWARE)
+	for _, param := range wundergroundParams {
+		value, exists := reading(condition, param.Sensor, param.Unit)
+		if !exists {
+			continue
+		}
+		query.Set(param.Name, strconv.FormatFloat(value, 'f', param.Precision, 64))
+	}
+	return query
+}
+
+func (self *Wunderground) Send(condition database.Condition) error {
+	endpoint, err := url.Parse(self.url)
+	if err != nil {
+		return err
+	}
+	endpoint.RawQuery = self.query(condition).Encode()
+
+	resp, err := self.client.Get(endpoint.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Upload failed with %v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if self.expect != "" && !strings.Contains(string(body), self.expect) {
+		return fmt.Errorf("Upload was rejected: %v", strings.TrimSpace(string(body)))
+	}
+	return nil
+}
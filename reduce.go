@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+// Parse a date or time from the command line in local time
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range []string{time.DateTime, "2006-01-02T15:04", time.DateOnly} {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %v: expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS", value)
+}
+
+func runReduce(args []string) error {
+	flags := flag.NewFlagSet("reduce", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v reduce [options] [config.toml]\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "\nWithout -from or -to, the retention policy is applied to every station.\n\n")
+		flags.PrintDefaults()
+	}
+	from := flags.String("from", "", "reduce the conditions starting at this date")
+	to := flags.String("to", "", "reduce the conditions before this date (now by default)")
+	interval := flags.Duration("interval", time.Hour, "keep one condition per interval of the range")
+	station := flags.String("station", "", "station to reduce (every station by default)")
+	flags.Parse(args)
+
+	conf, db, err := openDatabase(configPath(flags))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *from == "" && *to == "" {
+		if *station != "" {
+			return fmt.Errorf("-station needs -from or -to")
+		}
+		start := time.Now()
+		if err := database.ReduceConditions(db); err != nil {
+			return err
+		}
+		fmt.Printf("Reduced %v in %v\n", conf.Db, time.Since(start).Round(time.Millisecond))
+		return nil
+	}
+
+	if *interval <= 0 {
+		return fmt.Errorf("the interval must be positive")
+	}
+	begin := time.Time{}
+	if *from != "" {
+		if begin, err = parseDate(*from); err != nil {
+			return err
+		}
+	}
+	end := time.Now()
+	if *to != "" {
+		if end, err = parseDate(*to); err != nil {
+			return err
+		}
+	}
+	if !begin.Before(end) {
+		return fmt.Errorf("-from must be before -to")
+	}
+
+	stations := []string{*station}
+	if *station == "" {
+		if stations, err = database.FetchStations(db); err != nil {
+			return err
+		}
+	}
+	for _, id := range stations {
+		reduced, err := database.ReduceRange(db, id, begin, end, *interval)
+		if err != nil {
+			return fmt.Errorf("station %v: %w", id, err)
+		}
+		fmt.Printf("Reduced %v intervals of %v\n", reduced, id)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/station"
+	"github.com/ttocsneb/station-webapp/util"
+	"github.com/ttocsneb/station-webapp/web"
+)
+
+// Reloads the config of the running app
+type reloader struct {
+	path string
+	// The mqtt connection that the stations are using
+	client  mqtt.Client
+	connect func(conf *util.Config) (mqtt.Client, error)
+	sync.Mutex
+}
+
+// Keep a setting that needs a restart at its current value, noting the name of
+// the setting if it changed
+func keep[T any](changed *[]string, name string, value *T, current T) {
+	if !reflect.DeepEqual(*value, current) {
+		*changed = append(*changed, name)
+		*value = current
+	}
+}
+
+// Note the name of a setting that changed
+func compare(changed *[]string, name string, value any, current any) {
+	if !reflect.DeepEqual(value, current) {
+		*changed = append(*changed, name)
+	}
+}
+
+// Reload the config, applying the settings that can change while the app is
+// running. The settings that need a restart keep their current values until
+// then. Nothing is applied if the config isn't valid. Settings that fail to
+// apply are in Failed, alongside an error.
+func (self *reloader) reload() (web.ReloadReport, error) {
+	self.Lock()
+	defer self.Unlock()
+
+	report := web.ReloadReport{Applied: []string{}, Restart: []string{}, Failed: []string{}}
+	current := util.Conf()
+
+	conf, err := util.ReadConfig(self.path)
+	if err != nil {
+		return report, err
+	}
+	settings, err := readDatabaseSettings(conf)
+	if err != nil {
+		return report, err
+	}
+
+	keep(&report.Restart, "listen", &conf.Listen, current.Listen)
+	keep(&report.Restart, "base", &conf.Base, current.Base)
+	keep(&report.Restart, "db", &conf.Db, current.Db)
+	keep(&report.Restart, "broker", &conf.Broker, current.Broker)
+	keep(&report.Restart, "station_id", &conf.StationId, current.StationId)
+	keep(&report.Restart, "stations", &conf.Stations, current.Stations)
+	keep(&report.Restart, "uploads", &conf.Uploads, current.Uploads)
+	keep(&report.Restart, "homeassistant", &conf.HomeAssistant, current.HomeAssistant)
+	keep(&report.Restart, "admin", &conf.Admin, current.Admin)
+
+	// The alerts are checked before anything is replaced
+	if err := configureAlerts(conf); err != nil {
+		return report, err
+	}
+	settings.apply()
+
+	compare(&report.Applied, "retention", conf.Retention, current.Retention)
+	compare(&report.Applied, "sensors", conf.Sensors, current.Sensors)
+	compare(&report.Applied, "alerts", conf.Alerts, current.Alerts)
+	compare(&report.Applied, "notify", conf.Notify, current.Notify)
+	compare(&report.Applied, "health", conf.Health, current.Health)
+
+	mqtt_changed := []string{}
+	compare(&mqtt_changed, "mqtt_server", conf.MqttServer, current.MqttServer)
+	compare(&mqtt_changed, "mqtt_id", conf.MqttId, current.MqttId)
+	compare(&mqtt_changed, "mqtt", conf.Mqtt, current.Mqtt)
+	if len(mqtt_changed) > 0 {
+		client, err := station.Reconnect(self.client, func() (mqtt.Client, error) {
+			return self.connect(conf)
+		})
+		self.client = client
+		if err != nil {
+			conf.MqttServer = current.MqttServer
+			conf.MqttId = current.MqttId
+			conf.Mqtt = current.Mqtt
+			util.SetConf(conf)
+			report.Failed = mqtt_changed
+			if len(report.Applied) == 0 {
+				return report, fmt.Errorf("the mqtt settings were not applied: %w", err)
+			}
+			return report, fmt.Errorf(
+				"%v were applied, but the mqtt settings were not: %w",
+				strings.Join(report.Applied, ", "), err,
+			)
+		}
+		report.Applied = append(report.Applied, mqtt_changed...)
+	}
+
+	util.SetConf(conf)
+	return report, nil
+}
+
+// Reload the config, logging what happened
+func (self *reloader) logReload() {
+	logrus.Infof("Reloading %v", self.path)
+	report, err := self.reload()
+	if err != nil {
+		logrus.Errorf("Could not reload %v: %v", self.path, err)
+		return
+	}
+	if len(report.Applied) == 0 {
+		logrus.Info("Reloaded, nothing that can change live has changed")
+	} else {
+		logrus.Infof("Reloaded %v", strings.Join(report.Applied, ", "))
+	}
+	if len(report.Restart) > 0 {
+		logrus.Warnf("Restart to apply %v", strings.Join(report.Restart, ", "))
+	}
+}
+
+// Reload whenever the app receives SIGHUP, until ctx is done
+func (self *reloader) watchHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangup)
+		for {
+			select {
+			case <-hangup:
+				self.logReload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Disconnect from the mqtt server
+func (self *reloader) disconnect() {
+	self.Lock()
+	defer self.Unlock()
+	logrus.Info("Disconnecting from the mqtt server")
+	station.Disconnect(self.client)
+}
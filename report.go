@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/reports"
+	"github.com/ttocsneb/station-webapp/units"
+)
+
+func runReport(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v report [options] <YYYY-MM|YYYY> [config.toml]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	station := flags.String("station", "", "station to report on (the first station by default)")
+	system := flags.String("system", units.IMPERIAL, "units of the report (metric, imperial, or mixed)")
+	output := flags.String("o", "-", "file to write the report to")
+	flags.Parse(args)
+
+	if *system != units.METRIC && *system != units.IMPERIAL && *system != units.MIXED {
+		flags.Usage()
+		return fmt.Errorf("unknown system %v: expected metric, imperial, or mixed", *system)
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return fmt.Errorf("missing the month or year to report on")
+	}
+	period := flags.Arg(0)
+	monthly := true
+	date, err := time.ParseInLocation("2006-01", period, time.Local)
+	if err != nil {
+		monthly = false
+		date, err = time.ParseInLocation("2006", period, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid period %v: expected YYYY-MM or YYYY", period)
+		}
+	}
+
+	path := "conf.toml"
+	if flags.NArg() >= 2 {
+		path = flags.Arg(1)
+	}
+	conf, db, err := openDatabase(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report := reports.Report{System: *system}
+	for _, station_conf := range conf.Stations {
+		if *station == "" || station_conf.Id == *station {
+			report.Station = station_conf.Id
+			report.Name = station_conf.Name
+			break
+		}
+	}
+	if report.Station == "" {
+		if *station == "" {
+			return fmt.Errorf("no stations are configured")
+		}
+		return fmt.Errorf("unknown station %v", *station)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	buffered := bufio.NewWriter(w)
+	if monthly {
+		err = reports.Monthly(db, buffered, report, date.Year(), date.Month())
+	} else {
+		err = reports.Yearly(db, buffered, report, date.Year())
+	}
+	if err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
@@ -0,0 +1,450 @@
+package reports
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/units"
+)
+
+// The station that a report is for, and the units it is written in
+type Report struct {
+	Station string
+	Name    string
+	System  string
+}
+
+type extreme struct {
+	Value float64
+	Time  time.Time
+	Valid bool
+}
+
+func (self *extreme) max(value float64, t time.Time) {
+	if !self.Valid || value > self.Value {
+		*self = extreme{Value: value, Time: t, Valid: true}
+	}
+}
+
+func (self *extreme) min(value float64, t time.Time) {
+	if !self.Valid || value < self.Value {
+		*self = extreme{Value: value, Time: t, Valid: true}
+	}
+}
+
+type mean struct {
+	Sum   float64
+	Count int
+}
+
+func (self *mean) add(value float64) {
+	self.Sum += value
+	self.Count += 1
+}
+
+func (self mean) Value() (float64, bool) {
+	if self.Count == 0 {
+		return 0, false
+	}
+	return self.Sum / float64(self.Count), true
+}
+
+// The wind direction that the most wind came from
+type direction struct {
+	X float64
+	Y float64
+}
+
+func (self *direction) add(speed float64, angle float64) {
+	rad := angle * math.Pi / 180
+	self.X += speed * math.Cos(rad)
+	self.Y += speed * math.Sin(rad)
+}
+
+func (self direction) Value() (float64, bool) {
+	if self.X == 0 && self.Y == 0 {
+		return 0, false
+	}
+	deg := math.Atan2(self.Y, self.X) * 180 / math.Pi
+	if deg < 0 {
+		deg += 360
+	}
+	return deg, true
+}
+
+// A summary of a day or month
+type summary struct {
+	Date     time.Time
+	Temp     mean
+	High     extreme
+	Low      extreme
+	MeanHigh mean
+	MeanLow  mean
+	Heat     float64
+	Cool     float64
+	Rain     float64
+	RainMax  extreme
+	Wind     mean
+	Gust     extreme
+	Dir      direction
+	Valid    bool
+}
+
+type reportUnits struct {
+	Temp  string
+	Rain  string
+	Speed string
+	// Degree days are counted below/above this temperature
+	Base float64
+}
+
+func getReportUnits(system string) reportUnits {
+	result := reportUnits{
+		Temp:  units.SystemUnit("temp", system),
+		Rain:  units.SystemUnit("rain", system),
+		Speed: units.SystemUnit("speed", system),
+	}
+	if result.Temp == "" {
+		return getReportUnits(units.METRIC)
+	}
+	result.Base = 18.3
+	if result.Temp == "F" {
+		result.Base = 65
+	}
+	return result
+}
+
+// Get a sensor of a condition converted to a unit, trying each name in order
+func reading(condition database.Condition, unit string, names ...string) (float64, bool) {
+	for _, name := range names {
+		value, exists := condition.Sensors[name]
+		if !exists {
+			continue
+		}
+		converted, err := units.Convert(value, database.GetUnit(name), unit)
+		if err != nil {
+			return 0, false
+		}
+		return converted, true
+	}
+	return 0, false
+}
+
+func (self *summary) addCondition(condition database.Condition, u reportUnits) {
+	self.Valid = true
+	t := condition.Time.Local()
+
+	if temp, exists := reading(condition, u.Temp, "temp"); exists {
+		self.Temp.add(temp)
+		self.High.max(temp, t)
+		self.Low.min(temp, t)
+	}
+	// Reduced conditions keep the extremes of the conditions they replaced
+	if high, exists := reading(condition, u.Temp, "temp-max"); exists {
+		self.High.max(high, t)
+	}
+	if low, exists := reading(condition, u.Temp, "temp-min"); exists {
+		self.Low.min(low, t)
+	}
+
+	// The rain of the day is the most that dailyrain has been
+	if rain, exists := reading(condition, u.Rain, "dailyrain"); exists {
+		self.RainMax.max(rain, t)
+		self.Rain = self.RainMax.Value
+	}
+
+	if speed, exists := reading(condition, u.Speed, "windspd-avg2m", "windspd"); exists {
+		self.Wind.add(speed)
+		if dir, exists := reading(condition, "deg", "winddir-avg2m", "winddir"); exists {
+			self.Dir.add(speed, dir)
+		}
+	}
+	if gust, exists := reading(condition, u.Speed, "windgustspd-2m"); exists {
+		self.Gust.max(gust, t)
+	}
+}
+
+func (self *summary) finishDay(u reportUnits) {
+	temp, exists := self.Temp.Value()
+	if !exists {
+		return
+	}
+	self.Heat = math.Max(0, u.Base-temp)
+	self.Cool = math.Max(0, temp-u.Base)
+}
+
+// Combine the summary of a day into a longer period
+func (self *summary) addSummary(day summary) {
+	if !day.Valid {
+		return
+	}
+	self.Valid = true
+	if temp, exists := day.Temp.Value(); exists {
+		self.Temp.add(temp)
+	}
+	if day.High.Valid {
+		self.High.max(day.High.Value, day.High.Time)
+		self.MeanHigh.add(day.High.Value)
+	}
+	if day.Low.Valid {
+		self.Low.min(day.Low.Value, day.Low.Time)
+		self.MeanLow.add(day.Low.Value)
+	}
+	self.Heat += day.Heat
+	self.Cool += day.Cool
+	self.Rain += day.Rain
+	if day.RainMax.Valid {
+		self.RainMax.max(day.Rain, day.Date)
+	}
+	if wind, exists := day.Wind.Value(); exists {
+		self.Wind.add(wind)
+	}
+	if day.Gust.Valid {
+		self.Gust.max(day.Gust.Value, day.Gust.Time)
+	}
+	self.Dir.X += day.Dir.X
+	self.Dir.Y += day.Dir.Y
+}
+
+// Get how many calendar days t is after begin. Days aren't always 24 hours
+// long, so the dates are compared in UTC where they are.
+func dayIndex(begin time.Time, t time.Time) int {
+	from := time.Date(begin.Year(), begin.Month(), begin.Day(), 0, 0, 0, 0, time.UTC)
+	to := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// Summarize every day between begin and end
+func summarizeDays(db *sql.DB, report Report, begin time.Time, end time.Time) ([]summary, error) {
+	u := getReportUnits(report.System)
+
+	days := []summary{}
+	for day := begin; day.Before(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, summary{Date: day})
+	}
+
+	err := database.StreamConditions(db, report.Station, begin, end.Add(-time.Nanosecond), func(condition database.Condition) error {
+		index := dayIndex(begin, condition.Time.Local())
+		if index >= 0 && index < len(days) {
+			days[index].addCondition(condition, u)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range days {
+		days[i].finishDay(u)
+	}
+	return days, nil
+}
+
+// Format a value in a column, leaving it blank if the value is missing
+func column(width int, format string, value float64, valid bool) string {
+	if !valid {
+		return strings.Repeat(" ", width)
+	}
+	return fmt.Sprintf("%*s", width, fmt.Sprintf(format, value))
+}
+
+func textColumn(width int, text string, valid bool) string {
+	if !valid {
+		text = ""
+	}
+	return fmt.Sprintf("%*s", width, text)
+}
+
+// Write a line of a table without its trailing blank columns
+func row(w io.Writer, format string, args ...any) {
+	fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf(format, args...), " "))
+}
+
+func rainFormat(u reportUnits) string {
+	if u.Rain == "in" {
+		return "%.2f"
+	}
+	return "%.1f"
+}
+
+func writeHeader(w io.Writer, title string, report Report, u reportUnits) {
+	fmt.Fprintf(w, "%v\n\n", title)
+	fmt.Fprintf(w, "NAME: %v\n\n", report.Name)
+	fmt.Fprintf(
+		w, "TEMPERATURE (%v), RAIN (%v), WIND SPEED (%v)\n\n",
+		u.Temp, u.Rain, u.Speed,
+	)
+}
+
+// Monthly writes the climatological summary of a month with one line per day
+func Monthly(db *sql.DB, w io.Writer, report Report, year int, month time.Month) error {
+	u := getReportUnits(report.System)
+	begin := time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
+	days, err := summarizeDays(db, report, begin, begin.AddDate(0, 1, 0))
+	if err != nil {
+		return err
+	}
+
+	writeHeader(w, fmt.Sprintf("MONTHLY CLIMATOLOGICAL SUMMARY for %v", begin.Format("Jan 2006")), report, u)
+
+	rule := strings.Repeat("-", 83)
+	row(w, "%3s%7s%7s%7s%7s%7s%7s%7s%7s%7s%7s%7s%6s",
+		"", "", "", "", "", "", "HEAT", "COOL", "", "AVG", "", "", "")
+	row(w, "%3s%7s%7s%7s%7s%7s%7s%7s%7s%7s%7s%7s%6s",
+		"", "MEAN", "", "", "", "", "DEG", "DEG", "", "WIND", "", "", "DOM")
+	row(w, "%3s%7s%7s%7s%7s%7s%7s%7s%7s%7s%7s%7s%6s",
+		"DAY", "TEMP", "HIGH", "TIME", "LOW", "TIME", "DAYS", "DAYS", "RAIN", "SPEED", "HIGH", "TIME", "DIR")
+	fmt.Fprintln(w, rule)
+
+	total := summary{Date: begin}
+	for _, day := range days {
+		total.addSummary(day)
+
+		temp, has_temp := day.Temp.Value()
+		wind, has_wind := day.Wind.Value()
+		dir, has_dir := day.Dir.Value()
+		row(w, "%3s%v%v%v%v%v%v%v%v%v%v%v%v",
+			day.Date.Format("02"),
+			column(7, "%.1f", temp, has_temp),
+			column(7, "%.1f", day.High.Value, day.High.Valid),
+			textColumn(7, day.High.Time.Format("15:04"), day.High.Valid),
+			column(7, "%.1f", day.Low.Value, day.Low.Valid),
+			textColumn(7, day.Low.Time.Format("15:04"), day.Low.Valid),
+			column(7, "%.1f", day.Heat, has_temp),
+			column(7, "%.1f", day.Cool, has_temp),
+			column(7, rainFormat(u), day.Rain, day.RainMax.Valid),
+			column(7, "%.1f", wind, has_wind),
+			column(7, "%.1f", day.Gust.Value, day.Gust.Valid),
+			textColumn(7, day.Gust.Time.Format("15:04"), day.Gust.Valid),
+			column(6, "%.0f", dir, has_dir),
+		)
+	}
+	fmt.Fprintln(w, rule)
+
+	temp, has_temp := total.Temp.Value()
+	wind, has_wind := total.Wind.Value()
+	dir, has_dir := total.Dir.Value()
+	row(w, "%3s%v%v%v%v%v%v%v%v%v%v%v%v",
+		"",
+		column(7, "%.1f", temp, has_temp),
+		column(7, "%.1f", total.High.Value, total.High.Valid),
+		textColumn(7, total.High.Time.Format("02"), total.High.Valid),
+		column(7, "%.1f", total.Low.Value, total.Low.Valid),
+		textColumn(7, total.Low.Time.Format("02"), total.Low.Valid),
+		column(7, "%.1f", total.Heat, total.Valid),
+		column(7, "%.1f", total.Cool, total.Valid),
+		column(7, rainFormat(u), total.Rain, total.RainMax.Valid),
+		column(7, "%.1f", wind, has_wind),
+		column(7, "%.1f", total.Gust.Value, total.Gust.Valid),
+		textColumn(7, total.Gust.Time.Format("02"), total.Gust.Valid),
+		column(6, "%.0f", dir, has_dir),
+	)
+	return nil
+}
+
+// Yearly writes the climatological summary of a year with one line per month
+func Yearly(db *sql.DB, w io.Writer, report Report, year int) error {
+	u := getReportUnits(report.System)
+	begin := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+	days, err := summarizeDays(db, report, begin, begin.AddDate(1, 0, 0))
+	if err != nil {
+		return err
+	}
+
+	months := make([]summary, 12)
+	total := summary{Date: begin}
+	for i := range months {
+		months[i].Date = begin.AddDate(0, i, 0)
+	}
+	for _, day := range days {
+		months[day.Date.Month()-1].addSummary(day)
+		total.addSummary(day)
+	}
+	rows := append(months, total)
+	label := func(i int, month summary) string {
+		if i == len(rows)-1 {
+			return ""
+		}
+		return month.Date.Format("06 01")
+	}
+	// The day of an extreme, or the month for the whole year
+	day := func(i int, t time.Time) string {
+		if i == len(rows)-1 {
+			return t.Format("Jan")
+		}
+		return t.Format("02")
+	}
+
+	writeHeader(w, fmt.Sprintf("CLIMATOLOGICAL SUMMARY for year %v", year), report, u)
+
+	rule := strings.Repeat("-", 68)
+	fmt.Fprintln(w, "TEMPERATURE")
+	row(w, "%6s%7s%7s%7s%7s%7s%7s%5s%7s%5s",
+		"", "MEAN", "MEAN", "", "HEAT", "COOL", "", "", "", "")
+	row(w, "%6s%7s%7s%7s%7s%7s%7s%5s%7s%5s",
+		"", "MAX", "MIN", "MEAN", "DEG", "DEG", "", "", "", "")
+	row(w, "%6s%7s%7s%7s%7s%7s%7s%5s%7s%5s",
+		"YR MO", "TEMP", "TEMP", "TEMP", "DAYS", "DAYS", "HIGH", "DAY", "LOW", "DAY")
+	fmt.Fprintln(w, rule)
+	for i, month := range rows {
+		if i == len(rows)-1 {
+			fmt.Fprintln(w, rule)
+		}
+		high, has_high := month.MeanHigh.Value()
+		low, has_low := month.MeanLow.Value()
+		temp, has_temp := month.Temp.Value()
+		row(w, "%6s%v%v%v%v%v%v%v%v%v",
+			label(i, month),
+			column(7, "%.1f", high, has_high),
+			column(7, "%.1f", low, has_low),
+			column(7, "%.1f", temp, has_temp),
+			column(7, "%.1f", month.Heat, month.Valid),
+			column(7, "%.1f", month.Cool, month.Valid),
+			column(7, "%.1f", month.High.Value, month.High.Valid),
+			textColumn(5, day(i, month.High.Time), month.High.Valid),
+			column(7, "%.1f", month.Low.Value, month.Low.Valid),
+			textColumn(5, day(i, month.Low.Time), month.Low.Valid),
+		)
+	}
+
+	fmt.Fprintln(w, "\nPRECIPITATION")
+	row(w, "%6s%7s%7s%5s", "", "", "MAX", "")
+	row(w, "%6s%7s%7s%5s", "YR MO", "TOTAL", "OBS", "DAY")
+	fmt.Fprintln(w, rule)
+	for i, month := range rows {
+		if i == len(rows)-1 {
+			fmt.Fprintln(w, rule)
+		}
+		row(w, "%6s%v%v%v",
+			label(i, month),
+			column(7, rainFormat(u), month.Rain, month.RainMax.Valid),
+			column(7, rainFormat(u), month.RainMax.Value, month.RainMax.Valid),
+			textColumn(5, day(i, month.RainMax.Time), month.RainMax.Valid),
+		)
+	}
+
+	fmt.Fprintln(w, "\nWIND SPEED")
+	row(w, "%6s%7s%7s%5s%6s", "", "", "", "", "DOM")
+	row(w, "%6s%7s%7s%5s%6s", "YR MO", "AVG", "HIGH", "DAY", "DIR")
+	fmt.Fprintln(w, rule)
+	for i, month := range rows {
+		if i == len(rows)-1 {
+			fmt.Fprintln(w, rule)
+		}
+		wind, has_wind := month.Wind.Value()
+		dir, has_dir := month.Dir.Value()
+		row(w, "%6s%v%v%v%v",
+			label(i, month),
+			column(7, "%.1f", wind, has_wind),
+			column(7, "%.1f", month.Gust.Value, month.Gust.Valid),
+			textColumn(5, day(i, month.Gust.Time), month.Gust.Valid),
+			column(6, "%.0f", dir, has_dir),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package reports
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/units"
+)
+
+func openDb(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", t.TempDir()+"/db.sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Run a test in a time zone, since reports are written in local time
+func inZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %v is not available: %v", name, err)
+	}
+	local := time.Local
+	time.Local = loc
+	t.Cleanup(func() { time.Local = local })
+	return loc
+}
+
+func insert(t *testing.T, db *sql.DB, at time.Time, sensors map[string]float64) {
+	t.Helper()
+	condition := database.NewCondition("roof", at)
+	for sensor, value := range sensors {
+		condition.Sensors[sensor] = value
+	}
+	if err := condition.InsertDb(db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDayIndex(t *testing.T) {
+	loc := inZone(t, "America/New_York")
+	nov := time.Date(2024, time.November, 1, 0, 0, 0, 0, loc)
+	jan := time.Date(2024, time.January, 1, 0, 0, 0, 0, loc)
+	for _, tc := range []struct {
+		begin time.Time
+		t     time.Time
+		index int
+	}{
+		{nov, nov, 0},
+		{nov, time.Date(2024, time.November, 3, 0, 30, 0, 0, loc), 2},
+		// The 3rd is 25 hours long
+		{nov, time.Date(2024, time.November, 3, 23, 30, 0, 0, loc), 2},
+		{nov, time.Date(2024, time.November, 4, 0, 0, 0, 0, loc), 3},
+		{nov, time.Date(2024, time.November, 30, 23, 59, 0, 0, loc), 29},
+		{jan, time.Date(2024, time.March, 10, 23, 59, 0, 0, loc), 69},
+		{jan, time.Date(2024, time.December, 31, 23, 59, 0, 0, loc), 365},
+	} {
+		if index := dayIndex(tc.begin, tc.t); index != tc.index {
+			t.Errorf("expected %v to be day %v, got %v", tc.t, tc.index, index)
+		}
+	}
+}
+
+// Daylight saving ends in November, making the month 25 hours longer than 30
+// days
+func TestMonthlyFallBack(t *testing.T) {
+	loc := inZone(t, "America/New_York")
+	db := openDb(t)
+
+	begin := time.Date(2024, time.November, 1, 0, 0, 0, 0, loc)
+	end := begin.AddDate(0, 1, 0)
+	// Every reading of a day is the day of the month
+	for at := begin; at.Before(end); at = at.Add(time.Hour) {
+		insert(t, db, at, map[string]float64{"temp": float64(at.Day())})
+	}
+	insert(t, db, time.Date(2024, time.November, 30, 23, 59, 0, 0, loc), map[string]float64{"temp": 30})
+
+	report := Report{Station: "roof", Name: "Rooftop", System: units.METRIC}
+	days, err := summarizeDays(db, report, begin, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 30 {
+		t.Fatalf("expected 30 days, got %v", len(days))
+	}
+	for i, day := range days {
+		temp, exists := day.Temp.Value()
+		if !exists || temp != float64(i+1) {
+			t.Errorf("expected day %v to have a mean of %v, got %v", i+1, i+1, temp)
+		}
+	}
+	if count := days[2].Temp.Count; count != 25 {
+		t.Errorf("expected 25 readings on the 3rd, got %v", count)
+	}
+
+	var buf bytes.Buffer
+	if err := Monthly(db, &buf, report, 2024, time.November); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "MONTHLY CLIMATOLOGICAL SUMMARY for Nov 2024") {
+		t.Errorf("unexpected report:\n%v", buf.String())
+	}
+
+	buf.Reset()
+	if err := Yearly(db, &buf, report, 2024); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "CLIMATOLOGICAL SUMMARY for year 2024") {
+		t.Errorf("unexpected report:\n%v", buf.String())
+	}
+}
+
+func TestDaySummary(t *testing.T) {
+	loc := inZone(t, "UTC")
+	db := openDb(t)
+
+	day := time.Date(2024, time.June, 1, 0, 0, 0, 0, loc)
+	readings := []struct {
+		hour    int
+		sensors map[string]float64
+	}{
+		{0, map[string]float64{"temp": 10, "dailyrain": 0, "windspd-avg2m": 10, "winddir-avg2m": 90}},
+		{6, map[string]float64{"temp": 14, "dailyrain": 0.1, "windspd-avg2m": 20, "winddir-avg2m": 90}},
+		{12, map[string]float64{"temp": 20, "temp-max": 24, "dailyrain": 0.2, "windgustspd-2m": 40}},
+		{18, map[string]float64{"temp": 16, "temp-min": 8, "dailyrain": 0.2}},
+	}
+	for _, reading := range readings {
+		insert(t, db, day.Add(time.Duration(reading.hour)*time.Hour), reading.sensors)
+	}
+
+	report := Report{Station: "roof", System: units.METRIC}
+	days, err := summarizeDays(db, report, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := days[0]
+
+	temp, _ := summary.Temp.Value()
+	wind, _ := summary.Wind.Value()
+	dir, _ := summary.Dir.Value()
+	for _, tc := range []struct {
+		name  string
+		value float64
+		want  float64
+	}{
+		{"mean temp", temp, 15},
+		{"high", summary.High.Value, 24},
+		{"high hour", float64(summary.High.Time.Hour()), 12},
+		{"low", summary.Low.Value, 8},
+		{"low hour", float64(summary.Low.Time.Hour()), 18},
+		{"heating degrees", summary.Heat, 3.3},
+		{"cooling degrees", summary.Cool, 0},
+		{"rain", summary.Rain, 5.08},
+		{"wind", wind, 15},
+		{"direction", dir, 90},
+		{"gust", summary.Gust.Value, 40},
+	} {
+		if diff := tc.value - tc.want; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("expected the %v to be %v, got %v", tc.name, tc.want, tc.value)
+		}
+	}
+}
+
+func TestReportUnits(t *testing.T) {
+	for _, tc := range []struct {
+		system string
+		want   reportUnits
+	}{
+		{units.METRIC, reportUnits{Temp: "C", Rain: "mm", Speed: "km/h", Base: 18.3}},
+		{units.IMPERIAL, reportUnits{Temp: "F", Rain: "in", Speed: "mph", Base: 65}},
+	} {
+		if u := getReportUnits(tc.system); u != tc.want {
+			t.Errorf("expected the %v units to be %+v, got %+v", tc.system, tc.want, u)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+func runSensors(args []string) error {
+	flags := flag.NewFlagSet("sensors", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v sensors [options] [config.toml]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	station := flags.String("station", "", "only count the conditions of this station")
+	flags.Parse(args)
+
+	_, db, err := openDatabase(configPath(flags))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stats, err := database.FetchSensorStats(db, *station)
+	if err != nil {
+		return err
+	}
+
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SENSOR\tUNIT\tCOUNT\tFIRST SEEN\tLAST SEEN")
+	for _, sensor := range stats {
+		unit := database.GetUnit(sensor.Name)
+		if unit == "" {
+			unit = "-"
+		}
+		fmt.Fprintf(
+			w, "%v\t%v\t%v\t%v\t%v\n",
+			sensor.Name, unit, sensor.Count, formatTime(sensor.First), formatTime(sensor.Last),
+		)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/ttocsneb/station-webapp/alerts"
+	"github.com/ttocsneb/station-webapp/broker"
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/homeassistant"
+	"github.com/ttocsneb/station-webapp/station"
+	"github.com/ttocsneb/station-webapp/util"
+	"github.com/ttocsneb/station-webapp/web"
+)
+
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v [serve] [config.toml]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	return serve(configPath(flags))
+}
+
+// Serve the app until it is interrupted, then shut down in order: the web
+// server along with the stations, the mqtt connection, the reducer, and the
+// database. The config is reloaded on SIGHUP.
+func serve(path string) error {
+	conf, db, err := openDatabase(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	defer database.StopReducing()
+
+	if len(conf.Stations) == 0 {
+		return fmt.Errorf("no stations are configured")
+	}
+
+	if err := configureAlerts(conf); err != nil {
+		return err
+	}
+
+	var dial func() (net.Conn, error) = nil
+	if conf.Broker.Enabled {
+		embedded, err := broker.Start(conf.Broker)
+		if err != nil {
+			return fmt.Errorf("could not start the mqtt broker: %w", err)
+		}
+		defer embedded.Close()
+		dial = embedded.Dial
+	}
+
+	reloader := &reloader{path: path}
+	reloader.connect = func(conf *util.Config) (mqtt.Client, error) {
+		server := conf.MqttServer
+		if dial != nil {
+			server = "tcp://" + broker.IN_PROCESS
+		}
+		var available *station.Availability
+		if conf.HomeAssistant.Enabled {
+			available = homeassistant.Availability(conf.HomeAssistant)
+		}
+		client, err := station.Connect(conf.MqttId, server, conf.Mqtt, available, dial)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to %v: %w", server, err)
+		}
+		return client, nil
+	}
+
+	client, err := reloader.connect(conf)
+	if err != nil {
+		return err
+	}
+	reloader.client = client
+	defer reloader.disconnect()
+
+	var closed sync.Once
+	close_stations := func() {
+		closed.Do(func() {
+			for _, s := range station.All() {
+				s.Close()
+			}
+		})
+	}
+	defer close_stations()
+
+	for _, station_conf := range conf.Stations {
+		s, err := station.NewStation(db, client, station_conf)
+		if err != nil {
+			return fmt.Errorf("station %v: %w", station_conf.Id, err)
+		}
+		station.Add(s)
+		alerts.Watch(s.SubscribeUpdates())
+		if conf.HomeAssistant.Enabled {
+			homeassistant.Publish(db, s, conf.HomeAssistant)
+		}
+	}
+
+	if err := configureUploads(conf, db); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reloader.watchHangup(ctx)
+	return web.Main(ctx, db, station.All(), close_stations, reloader.reload)
+}
@@ -0,0 +1,53 @@
+package station
+
+import (
+	"github.com/ttocsneb/station-webapp/metrics"
+)
+
+var sensorValues = metrics.NewGauge(
+	"station_sensor_value", "The latest value of each sensor, in the unit it is stored in",
+	"station", "sensor",
+)
+
+var mqttMessages = metrics.NewCounter(
+	"station_mqtt_messages_total", "Weather messages received over mqtt",
+	"station",
+)
+
+var mqttParseFailures = metrics.NewCounter(
+	"station_mqtt_parse_failures_total", "Weather messages that could not be parsed",
+	"station",
+)
+
+var insertDuration = metrics.NewHistogram(
+	"station_insert_duration_seconds", "How long it takes to store received conditions",
+	metrics.DefaultBuckets, "station",
+)
+
+var _ = metrics.NewGaugeFunc(
+	"station_rapid_updates", "Whether rapid updates are being received from a station",
+	[]string{"station"},
+	func(set func(float64, ...string)) {
+		for _, station := range All() {
+			running := 0.0
+			if station.rapid_running.Load() {
+				running = 1
+			}
+			set(running, station.station)
+		}
+	},
+)
+
+var _ = metrics.NewGaugeFunc(
+	"station_online", "Whether a station has sent conditions recently",
+	[]string{"station"},
+	func(set func(float64, ...string)) {
+		for _, station := range All() {
+			online := 0.0
+			if station.Status().Online {
+				online = 1
+			}
+			set(online, station.station)
+		}
+	},
+)
@@ -1,29 +1,90 @@
 package station
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang"
 	"github.com/sirupsen/logrus"
 	"github.com/ttocsneb/station-webapp/database"
 	"github.com/ttocsneb/station-webapp/util"
+	"github.com/ttocsneb/station-webapp/weather"
 )
 
-var Client *Station = nil
+var stations []*Station = nil
+var stations_lock sync.RWMutex
+
+// Add a station to the stations that the app receives conditions from
+func Add(station *Station) {
+	stations_lock.Lock()
+	defer stations_lock.Unlock()
+	stations = append(stations, station)
+}
+
+// Get every station that the app receives conditions from
+func All() []*Station {
+	stations_lock.RLock()
+	defer stations_lock.RUnlock()
+	return append([]*Station(nil), stations...)
+}
+
+// The station has been closed and no longer receives conditions
+var ErrClosed = errors.New("Station is closed")
+
+// Find a station by its id
+func Find(id string) *Station {
+	for _, station := range All() {
+		if station.station == id {
+			return station
+		}
+	}
+	return nil
+}
 
 type Station struct {
-	Client        mqtt.Client
-	db            *sql.DB
-	station       string
-	updates       *util.ChanMux[database.Condition]
-	rapid         *util.ChanMux[database.Condition]
-	updates_chan  chan database.Condition
-	rapid_chan    chan database.Condition
-	rapid_done    chan any
-	rapid_running bool
+	client       mqtt.Client
+	client_lock  sync.RWMutex
+	db           *sql.DB
+	station      string
+	name         string
+	location     string
+	latitude     float64
+	elevation    *float64
+	updates      *util.ChanMux[database.Condition]
+	rapid        *util.ChanMux[database.Condition]
+	updates_chan chan database.Condition
+	rapid_chan   chan database.Condition
+	rapid_done   chan any
+	// Set while anyone is watching the rapid weather, from when it starts until
+	// it is stopped
+	rapid_running atomic.Bool
+	// Whether the rapid weather is subscribed to, so that a failed subscribe is
+	// tried again
+	rapid_subscribed atomic.Bool
+	// Held by the goroutine that requests the rapid weather, so that a new one
+	// only subscribes once the last one has unsubscribed
+	rapid_lock   sync.Mutex
+	status       *util.ChanMux[Status]
+	status_chan  chan Status
+	status_lock  sync.Mutex
+	last_message time.Time
+	stale_after  time.Duration
+	key          string
+	// Closed once the station stops, after which nothing is sent to the
+	// subscribers
+	done       chan any
+	closed     bool
+	close_lock sync.RWMutex
 }
 
 func WaitOrErr(fut mqtt.Token) error {
@@ -31,91 +92,309 @@ func WaitOrErr(fut mqtt.Token) error {
 	return fut.Error()
 }
 
-func NewStation(db *sql.DB, client_id string, station_id string, server string) (*Station, error) {
+// The quality of service that stations are subscribed with, which changes
+// when the mqtt settings are reloaded
+var qos atomic.Uint32
+
+func getQos() byte {
+	return byte(qos.Load())
+}
+
+func loadTLSConfig(conf util.MqttConfig) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify}
+	if conf.CaFile != "" {
+		ca, err := os.ReadFile(conf.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No certificates found in %v", conf.CaFile)
+		}
+	}
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Availability tells others whether the app is connected. Online is published
+// to the topic whenever the app connects, and the mqtt server publishes Offline
+// as the will of the app if it disconnects without saying so. Both are
+// retained.
+type Availability struct {
+	Topic   string
+	Online  string
+	Offline string
+}
+
+// The availability of the connection, if it has one
+var availability atomic.Pointer[Availability]
+
+func (self *Availability) publish(client mqtt.Client, payload string) {
+	token := client.Publish(self.Topic, 1, true, payload)
+	if !token.WaitTimeout(time.Second * 5) {
+		logrus.Warnf("Timed out publishing to %v", self.Topic)
+	} else if err := token.Error(); err != nil {
+		logrus.Warnf("Could not publish to %v: %v", self.Topic, err)
+	}
+}
+
+// Connect to the mqtt server that every station is shared through. The
+// connection is kept alive, and every station is resubscribed whenever it is
+// re-established. Availability is optional. A dial function connects without
+// going through the network, such as to the embedded broker.
+func Connect(
+	client_id string, server string, conf util.MqttConfig,
+	available *Availability, dial func() (net.Conn, error),
+) (mqtt.Client, error) {
+	if conf.Qos > 2 {
+		return nil, fmt.Errorf("Invalid qos %v", conf.Qos)
+	}
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(server)
 	opts.SetClientID(client_id)
 	opts.SetOrderMatters(false)
+	opts.SetUsername(conf.Username)
+	opts.SetPassword(conf.Password)
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(time.Minute)
+	if conf.Keepalive.Duration > 0 {
+		opts.SetKeepAlive(conf.Keepalive.Duration)
+	}
+	if conf.CleanSession != nil {
+		opts.SetCleanSession(*conf.CleanSession)
+	}
+	if conf.CaFile != "" || conf.CertFile != "" || conf.KeyFile != "" || conf.InsecureSkipVerify {
+		tls_config, err := loadTLSConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tls_config)
+	}
+
+	if available != nil {
+		opts.SetWill(available.Topic, available.Offline, 1, true)
+	}
+
+	if dial != nil {
+		opts.SetCustomOpenConnectionFn(func(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+			return dial()
+		})
+	}
+
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		logrus.Infof("Connected to %v", server)
+		setConnected(true)
+		// Subscriptions don't survive a new session, so they are made again.
+		// Waiting on them from the handler would block the client.
+		for _, station := range All() {
+			if station.Client() == client {
+				go station.resubscribe()
+			}
+		}
+		if available != nil {
+			go available.publish(client, available.Online)
+		}
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		logrus.Warnf("Lost connection to %v: %v", server, err)
+		setConnected(false)
+	})
+	opts.SetReconnectingHandler(func(client mqtt.Client, opts *mqtt.ClientOptions) {
+		logrus.Infof("Reconnecting to %v", server)
+	})
 	client := mqtt.NewClient(opts)
 
 	if err := WaitOrErr(client.Connect()); err != nil {
 		return nil, err
 	}
+	qos.Store(uint32(conf.Qos))
+	availability.Store(available)
+	return client, nil
+}
+
+// Disconnect from the mqtt server, publishing that the app is offline first
+// since the server only publishes the will when the app disconnects
+// unexpectedly
+func Disconnect(client mqtt.Client) {
+	if available := availability.Load(); available != nil && client.IsConnectionOpen() {
+		available.publish(client, available.Offline)
+	}
+	client.Disconnect(250)
+}
+
+// Reconnect replaces the mqtt connection that every station shares. The old
+// connection is closed first so that a new connection with the same id isn't
+// kicked off by it. If the new connection fails, the old one is connected
+// again. Returns the connection that the stations are using.
+func Reconnect(old mqtt.Client, connect func() (mqtt.Client, error)) (mqtt.Client, error) {
+	logrus.Info("Reconnecting to the mqtt server with new settings")
+	old.Disconnect(250)
+
+	client, err := connect()
+	if err != nil {
+		// The stations resubscribe once the old connection is back
+		if err := WaitOrErr(old.Connect()); err != nil {
+			logrus.Errorf("Could not connect with the old settings either: %v", err)
+		}
+		return old, err
+	}
+
+	for _, station := range All() {
+		station.client_lock.Lock()
+		station.client = client
+		station.client_lock.Unlock()
+		station.resubscribe()
+	}
+	return client, nil
+}
+
+func NewStation(db *sql.DB, client mqtt.Client, conf util.StationConfig) (*Station, error) {
+	station_id := conf.Id
 	updates_chan := make(chan database.Condition)
 	rapid_chan := make(chan database.Condition)
+	status_chan := make(chan Status)
+	stale_after := conf.StaleAfter.Duration
+	if stale_after <= 0 {
+		stale_after = DEFAULT_STALE_AFTER
+	}
 	self := &Station{
-		Client:        client,
-		db:            db,
-		station:       station_id,
-		updates:       util.NewChanMux(updates_chan),
-		rapid:         util.NewChanMux(rapid_chan),
-		updates_chan:  updates_chan,
-		rapid_chan:    rapid_chan,
-		rapid_done:    make(chan any),
-		rapid_running: false,
+		client:       client,
+		db:           db,
+		station:      station_id,
+		name:         conf.Name,
+		location:     conf.Location,
+		latitude:     conf.Latitude,
+		elevation:    conf.Elevation,
+		updates:      util.NewChanMux(updates_chan),
+		rapid:        util.NewChanMux(rapid_chan),
+		updates_chan: updates_chan,
+		rapid_chan:   rapid_chan,
+		rapid_done:   make(chan any),
+		status:       util.NewChanMux(status_chan),
+		status_chan:  status_chan,
+		stale_after:  stale_after,
+		key:          conf.Key,
+		done:         make(chan any),
+	}
+	if err := self.loadLastMessage(db); err != nil {
+		return nil, err
 	}
 	self.rapid.OnEmpty = self.stopRapdiUpdates
 	self.rapid.OnSubscribe = self.startRapidUpdates
 
-	if err := WaitOrErr(client.Subscribe(fmt.Sprintf("/station/weather/%v", station_id), 0, self.weatherListener())); err != nil {
+	if err := self.subscribe(); err != nil {
 		return nil, err
 	}
-	logrus.Infof("Subscribing to /station/weather/%v", station_id)
+
+	go self.monitor()
 
 	return self, nil
 }
 
+func (self *Station) subscribe() error {
+	topic := fmt.Sprintf("/station/weather/%v", self.station)
+	logrus.Infof("Subscribing to %v", topic)
+	return WaitOrErr(self.Client().Subscribe(topic, getQos(), self.weatherListener()))
+}
+
+// Subscribe to the station again after reconnecting, along with the rapid
+// weather if anyone is watching it
+func (self *Station) resubscribe() {
+	if err := self.subscribe(); err != nil {
+		logrus.Errorf("Could not resubscribe to %v: %v", self.station, err)
+	}
+	if self.rapid_running.Load() {
+		if err := self.subscribeRapid(); err != nil {
+			logrus.Errorf("Could not resubscribe to rapid updates of %v: %v", self.station, err)
+		}
+	}
+}
+
+// Read the sensors of a message into a condition. Every reading is converted to
+// the unit its sensor is stored in, and readings in a unit that can't be
+// converted are dropped. Derived sensors such as the heat index are then added.
+func (self *Station) readSensors(condition *database.Condition, sensors map[string][]sensorValue) {
+	for sensor, values := range sensors {
+		if len(values) == 0 {
+			continue
+		}
+		value, err := database.NormalizeReading(self.db, sensor, values[0].Value, values[0].Unit)
+		if err != nil {
+			logrus.Warnf("Dropping reading from %v: %v", self.station, err)
+			continue
+		}
+		condition.Sensors[sensor] = value
+	}
+	weather.Derive(condition.Sensors)
+}
+
 func (self *Station) weatherListener() mqtt.MessageHandler {
 	return func(cient mqtt.Client, msg mqtt.Message) {
+		mqttMessages.Inc(self.station)
 		var payload weatherMessage
 		if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
+			mqttParseFailures.Inc(self.station)
 			logrus.Errorf("Unable to parse message: %v\n", err)
 			return
 		}
 
-		conditions := database.NewCondition(payload.Time)
-
-		for sensor, value := range payload.Sensors {
-			if len(value) == 0 {
-				continue
-			}
-			val := value[0]
-			conditions.Sensors[sensor] = val.Value
-		}
-
-		if err := conditions.InsertDb(self.db); err != nil {
+		if err := self.receive(payload.Time, payload.Sensors); err != nil {
 			logrus.Errorf("Unable to insert condition to db: %v\n", err)
-			return
 		}
+	}
+}
 
-		logrus.Info("Received conditions update")
+// Store the conditions received from the station and send them to everyone
+// watching it, however they were received
+func (self *Station) receive(t time.Time, sensors map[string][]sensorValue) error {
+	self.close_lock.RLock()
+	defer self.close_lock.RUnlock()
+	if self.closed {
+		return ErrClosed
+	}
 
-		self.updates_chan <- conditions
+	self.received(time.Now())
+	conditions := database.NewCondition(self.station, t)
+	self.readSensors(&conditions, sensors)
 
-		yes, err := database.IsTimeToReduce(self.db)
-		if err != nil {
-			logrus.Error(err)
-			return
-		}
-		if yes {
-			go func() {
-				logrus.Info("Reducing database")
-				err := database.ReduceConditions(self.db)
-				if err != nil {
-					logrus.Errorf("Error While reducing database: %v\n", err)
-				}
-			}()
-		}
+	start := time.Now()
+	if err := conditions.InsertDb(self.db); err != nil {
+		return err
+	}
+	insertDuration.Observe(time.Since(start).Seconds(), self.station)
+	for sensor, value := range conditions.Sensors {
+		sensorValues.Set(value, self.station, sensor)
 	}
-}
 
-func (self *Station) startRapidUpdates() {
-	logrus.Info("Starting rapid updates")
-	subscription := fmt.Sprintf("/station/rapid-weather/%v", self.station)
-	request := fmt.Sprintf("/station/request/%v", self.station)
+	logrus.Info("Received conditions update")
 
-	logrus.Infof("Subscribing to %v", subscription)
-	err := WaitOrErr(self.Client.Subscribe(subscription, 1, func(client mqtt.Client, msg mqtt.Message) {
+	self.updates_chan <- conditions
+
+	yes, err := database.IsTimeToReduce(self.db)
+	if err != nil {
+		logrus.Error(err)
+		return nil
+	}
+	if yes {
+		go func() {
+			logrus.Info("Reducing database")
+			err := database.ReduceConditions(self.db)
+			if err != nil {
+				logrus.Errorf("Error While reducing database: %v\n", err)
+			}
+		}()
+	}
+	return nil
+}
+
+func (self *Station) rapidListener() mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
 		var payload weatherMessage
 		err := json.Unmarshal(msg.Payload(), &payload)
 		if err != nil {
@@ -123,51 +402,75 @@ func (self *Station) startRapidUpdates() {
 			return
 		}
 
-		message := database.NewCondition(payload.Time)
-
-		for sensor, values := range payload.Sensors {
-			if len(values) == 0 {
-				continue
-			}
-			message.Sensors[sensor] = values[0].Value
+		self.close_lock.RLock()
+		defer self.close_lock.RUnlock()
+		if self.closed {
+			return
 		}
 
+		self.received(time.Now())
+		message := database.NewCondition(self.station, payload.Time)
+		self.readSensors(&message, payload.Sensors)
+
 		self.rapid_chan <- message
-	}))
-	if err != nil {
-		logrus.Errorf("%v\n", err)
-		return
 	}
+}
 
+func (self *Station) requestRapid() error {
+	request := fmt.Sprintf("/station/request/%v", self.station)
 	payload, err := json.Marshal(requestMessage{Action: "rapid-weather"})
 	if err != nil {
-		logrus.Errorf("%v\n", err)
-		return
+		return err
 	}
+	logrus.Infof("Publishing to %v", request)
+	return WaitOrErr(self.Client().Publish(request, max(getQos(), 1), false, payload))
+}
 
-	err = WaitOrErr(self.Client.Publish(request, 1, false, payload))
+// Subscribe to the rapid weather and ask the station to start sending it
+func (self *Station) subscribeRapid() error {
+	subscription := fmt.Sprintf("/station/rapid-weather/%v", self.station)
+	logrus.Infof("Subscribing to %v", subscription)
+	err := WaitOrErr(self.Client().Subscribe(subscription, max(getQos(), 1), self.rapidListener()))
+	self.rapid_subscribed.Store(err == nil)
 	if err != nil {
-		logrus.Errorf("%v\n", err)
+		return err
+	}
+	return self.requestRapid()
+}
+
+// Start receiving the rapid weather, which the station has to be asked for
+// again every 50 seconds. A subscribe that fails is tried again then.
+func (self *Station) startRapidUpdates() {
+	if !self.rapid_running.CompareAndSwap(false, true) {
 		return
 	}
+	logrus.Info("Starting rapid updates")
+	subscription := fmt.Sprintf("/station/rapid-weather/%v", self.station)
 
 	go func() {
-		self.rapid_running = true
+		self.rapid_lock.Lock()
+		defer self.rapid_lock.Unlock()
+
+		if err := self.subscribeRapid(); err != nil {
+			logrus.Errorf("Could not subscribe to rapid-weather updates: %v\n", err)
+		}
 		timeout := time.After(time.Second * 50)
 		for true {
 			select {
 			case <-self.rapid_done:
-				err := WaitOrErr(self.Client.Unsubscribe(subscription))
+				err := WaitOrErr(self.Client().Unsubscribe(subscription))
 				if err != nil {
 					logrus.Errorf("Could not Unsubscribe from rapid-weather updates: %v\n", err)
 				}
-				self.rapid_running = false
+				self.rapid_subscribed.Store(false)
 				logrus.Infof("Unsubscribe from %v", subscription)
 				return
 			case <-timeout:
-				logrus.Infof("Publishing to %v", request)
-				err := WaitOrErr(self.Client.Publish(request, 1, false, payload))
-				if err != nil {
+				if !self.rapid_subscribed.Load() {
+					if err := self.subscribeRapid(); err != nil {
+						logrus.Errorf("Could not subscribe to rapid-weather updates: %v\n", err)
+					}
+				} else if err := self.requestRapid(); err != nil {
 					logrus.Errorf("Could not send rapid-weather request: %v\n", err)
 				}
 				timeout = time.After(time.Second * 50)
@@ -177,11 +480,64 @@ func (self *Station) startRapidUpdates() {
 }
 
 func (self *Station) stopRapdiUpdates() {
-	if self.rapid_running {
+	if self.rapid_running.CompareAndSwap(true, false) {
 		self.rapid_done <- true
 	}
 }
 
+// Close stops receiving conditions from the station, and closes every
+// subscription to it
+func (self *Station) Close() {
+	topic := fmt.Sprintf("/station/weather/%v", self.station)
+	token := self.Client().Unsubscribe(topic)
+	if !token.WaitTimeout(time.Second * 5) {
+		logrus.Warnf("Timed out unsubscribing from %v", topic)
+	} else if err := token.Error(); err != nil {
+		logrus.Warnf("Could not unsubscribe from %v: %v", topic, err)
+	}
+	self.stopRapdiUpdates()
+
+	self.close_lock.Lock()
+	defer self.close_lock.Unlock()
+	if self.closed {
+		return
+	}
+	self.closed = true
+	close(self.done)
+	close(self.updates_chan)
+	close(self.rapid_chan)
+	close(self.status_chan)
+}
+
+// The mqtt connection that the station is received through
+func (self *Station) Client() mqtt.Client {
+	self.client_lock.RLock()
+	defer self.client_lock.RUnlock()
+	return self.client
+}
+
+func (self *Station) Id() string {
+	return self.station
+}
+
+func (self *Station) Name() string {
+	return self.name
+}
+
+func (self *Station) Location() string {
+	return self.location
+}
+
+func (self *Station) Latitude() float64 {
+	return self.latitude
+}
+
+// Elevation gets the meters above sea level of the station, or nil if it isn't
+// configured
+func (self *Station) Elevation() *float64 {
+	return self.elevation
+}
+
 func (self *Station) SubscribeUpdates() chan database.Condition {
 	return self.updates.Subscribe(1)
 }
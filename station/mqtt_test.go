@@ -0,0 +1,91 @@
+package station
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+func openDb(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", t.TempDir()+"/db.sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestReadSensors(t *testing.T) {
+	station := &Station{db: openDb(t), station: "roof"}
+
+	var payload weatherMessage
+	err := json.Unmarshal([]byte(`{
+		"time": "2024-06-01T12:00:00Z",
+		"id": "roof",
+		"sensors": {
+			"temp": [{"unit": "F", "value": 68}, {"unit": "C", "value": 30}],
+			"barom": [{"unit": "inHg", "value": 29.92}],
+			"humidity": [{"value": 50}],
+			"windspd": [{"unit": "m/s", "value": 5}],
+			"dailyrain": [{"unit": "mm", "value": 12.7}],
+			"uv": [],
+			"winddir": [{"unit": "hPa", "value": 90}]
+		}
+	}`), &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	condition := database.NewCondition("roof", payload.Time)
+	station.readSensors(&condition, payload.Sensors)
+
+	if !condition.Time.Equal(time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected time %v", condition.Time)
+	}
+	for _, tc := range []struct {
+		sensor string
+		want   float64
+	}{
+		// Only the first reading of a sensor is used
+		{"temp", 20},
+		{"barom", 1013.2},
+		{"humidity", 50},
+		{"windspd", 18},
+		{"dailyrain", 0.5},
+	} {
+		if got, exists := condition.Sensors[tc.sensor]; !exists || math.Abs(got-tc.want) > 0.01 {
+			t.Errorf("expected %v to be %v, got %v", tc.sensor, tc.want, got)
+		}
+	}
+	for _, sensor := range []string{"uv", "winddir"} {
+		if value, exists := condition.Sensors[sensor]; exists {
+			t.Errorf("expected %v to be dropped, got %v", sensor, value)
+		}
+	}
+}
+
+func TestReadSensorsAllDropped(t *testing.T) {
+	db := openDb(t)
+	station := &Station{db: db, station: "roof"}
+
+	condition := database.NewCondition("roof", time.Now())
+	station.readSensors(&condition, map[string][]sensorValue{
+		"temp": {{Unit: "hPa", Value: 20}},
+	})
+	if len(condition.Sensors) != 0 {
+		t.Errorf("expected every reading to be dropped, got %v", condition.Sensors)
+	}
+	if err := condition.InsertDb(db); !errors.Is(err, database.ErrEmptyCondition) {
+		t.Errorf("expected an empty condition to not be stored, got %v", err)
+	}
+}
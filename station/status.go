@@ -0,0 +1,129 @@
+package station
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+// A station is offline when it hasn't sent conditions for this long
+const DEFAULT_STALE_AFTER = time.Minute * 10
+
+type Status struct {
+	Online bool
+	// Whether the mqtt server is connected
+	Connected bool
+	// When the last conditions were received, which is zero if there never
+	// were any
+	LastMessage time.Time
+	// When the connection to the mqtt server was lost
+	Disconnected time.Time
+}
+
+var connection = struct {
+	connected    bool
+	disconnected time.Time
+	sync.Mutex
+}{}
+
+func setConnected(connected bool) {
+	connection.Lock()
+	defer connection.Unlock()
+	if !connected && connection.connected {
+		connection.disconnected = time.Now()
+	}
+	connection.connected = connected
+}
+
+// IsConnected tells whether the mqtt server is connected
+func IsConnected() bool {
+	connection.Lock()
+	defer connection.Unlock()
+	return connection.connected
+}
+
+// Start from the last condition in the database, so that a station that was
+// already offline is still shown as offline after a restart
+func (self *Station) loadLastMessage(db *sql.DB) error {
+	condition, err := database.FetchLatestCondition(db, self.station)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	self.last_message = condition.Time
+	return nil
+}
+
+func (self *Station) received(t time.Time) {
+	self.status_lock.Lock()
+	defer self.status_lock.Unlock()
+	if t.After(self.last_message) {
+		self.last_message = t
+	}
+}
+
+func (self *Station) Status() Status {
+	self.status_lock.Lock()
+	last_message := self.last_message
+	self.status_lock.Unlock()
+
+	connection.Lock()
+	defer connection.Unlock()
+	return Status{
+		Online:       connection.connected && time.Since(last_message) < self.stale_after,
+		Connected:    connection.connected,
+		LastMessage:  last_message,
+		Disconnected: connection.disconnected,
+	}
+}
+
+// Check the status of the station every so often, and publish it whenever it
+// changes
+func (self *Station) monitor() {
+	ticker := time.NewTicker(min(self.stale_after/4, time.Second*30))
+	defer ticker.Stop()
+	last := self.Status()
+	for {
+		select {
+		case <-self.done:
+			return
+		case <-ticker.C:
+		}
+		status := self.Status()
+		if status.Online == last.Online && status.Connected == last.Connected {
+			continue
+		}
+		if status.Online {
+			logrus.Infof("Station %v is online", self.station)
+		} else {
+			logrus.Warnf("Station %v is offline since %v", self.station, status.LastMessage.Local())
+		}
+		last = status
+		self.publishStatus(status)
+	}
+}
+
+func (self *Station) publishStatus(status Status) {
+	self.close_lock.RLock()
+	defer self.close_lock.RUnlock()
+	if !self.closed {
+		self.status_chan <- status
+	}
+}
+
+func (self *Station) StaleAfter() time.Duration {
+	return self.stale_after
+}
+
+func (self *Station) SubscribeStatus() chan Status {
+	return self.status.Subscribe(1)
+}
+func (self *Station) UnsubscribeStatus(c chan Status) {
+	self.status.Unsubscribe(c)
+}
@@ -0,0 +1,136 @@
+package station
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The upload could not be read
+var ErrInvalidUpload = errors.New("Invalid upload")
+
+// The sensor that a field of an upload is read into, and the unit it is in
+type uploadField struct {
+	Sensor string
+	Unit   string
+}
+
+// Fields of the Weather Underground updateweatherstation.php protocol
+var wundergroundFields = map[string]uploadField{
+	"tempf":             {"temp", "F"},
+	"dewptf":            {"dewpoint", "F"},
+	"humidity":          {"humidity", "%"},
+	"baromin":           {"barom-sea", "inHg"},
+	"windspeedmph":      {"windspd", "mph"},
+	"winddir":           {"winddir", "deg"},
+	"windspdmph_avg2m":  {"windspd-avg2m", "mph"},
+	"winddir_avg2m":     {"winddir-avg2m", "deg"},
+	"windspdmph_avg10m": {"windspd-avg10m", "mph"},
+	"winddir_avg10m":    {"winddir-avg10m", "deg"},
+	"windgustmph":       {"windgustspd-2m", "mph"},
+	"windgustdir":       {"windgustdir-2m", "deg"},
+	"rainin":            {"rain-1h", "in"},
+	"dailyrainin":       {"dailyrain", "in"},
+	"UV":                {"uv", ""},
+	"solarradiation":    {"solarradiation", ""},
+	"indoortempf":       {"indoortemp", "F"},
+	"indoorhumidity":    {"indoorhumidity", "%"},
+	"soiltempf":         {"soiltemp", "F"},
+	"soilmoisture":      {"soilmoisture", "%"},
+}
+
+// Fields of the Ecowitt custom server protocol
+var ecowittFields = map[string]uploadField{
+	"tempf":          {"temp", "F"},
+	"humidity":       {"humidity", "%"},
+	"baromabsin":     {"barom", "inHg"},
+	"baromrelin":     {"barom-sea", "inHg"},
+	"windspeedmph":   {"windspd", "mph"},
+	"winddir":        {"winddir", "deg"},
+	"windgustmph":    {"windgustspd-2m", "mph"},
+	"hourlyrainin":   {"rain-1h", "in"},
+	"dailyrainin":    {"dailyrain", "in"},
+	"uv":             {"uv", ""},
+	"solarradiation": {"solarradiation", ""},
+	"tempinf":        {"indoortemp", "F"},
+	"humidityin":     {"indoorhumidity", "%"},
+}
+
+// Get the time of an upload, which is given in UTC or as "now"
+func parseUploadTime(value string) (time.Time, error) {
+	if value == "" || value == "now" {
+		return time.Now(), nil
+	}
+	t, err := time.ParseInLocation(time.DateTime, value, time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: dateutc %v", ErrInvalidUpload, value)
+	}
+	return t, nil
+}
+
+func readUpload(form url.Values, fields map[string]uploadField) (map[string][]sensorValue, error) {
+	sensors := make(map[string][]sensorValue)
+	for name, field := range fields {
+		text := form.Get(name)
+		if text == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v %v", ErrInvalidUpload, name, text)
+		}
+		// Weather Underground marks readings that are missing with -9999
+		if value == -9999 {
+			continue
+		}
+		sensors[field.Sensor] = []sensorValue{{Unit: field.Unit, Value: value}}
+	}
+	return sensors, nil
+}
+
+func (self *Station) receiveUpload(form url.Values, fields map[string]uploadField) error {
+	t, err := parseUploadTime(form.Get("dateutc"))
+	if err != nil {
+		return err
+	}
+	sensors, err := readUpload(form, fields)
+	if err != nil {
+		return err
+	}
+	if len(sensors) == 0 {
+		return fmt.Errorf("%w: there are no readings", ErrInvalidUpload)
+	}
+	return self.receive(t, sensors)
+}
+
+// ReceiveWunderground stores the conditions of a Weather Underground upload
+func (self *Station) ReceiveWunderground(form url.Values) error {
+	return self.receiveUpload(form, wundergroundFields)
+}
+
+// ReceiveEcowitt stores the conditions of an Ecowitt upload
+func (self *Station) ReceiveEcowitt(form url.Values) error {
+	return self.receiveUpload(form, ecowittFields)
+}
+
+// Check the key that a station uploaded with. Stations without a key can't
+// upload.
+func (self *Station) CheckKey(key string) bool {
+	if self.key == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(self.key)) == 1
+}
+
+// Find the station that uploads with a key
+func FindByKey(key string) *Station {
+	for _, station := range All() {
+		if station.CheckKey(key) {
+			return station
+		}
+	}
+	return nil
+}
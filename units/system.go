@@ -0,0 +1,79 @@
+package units
+
+import "math"
+
+const IMPERIAL = "imperial"
+const METRIC = "metric"
+const MIXED = "mixed"
+
+// The kinds of units that are displayed differently in each system
+var kinds = map[string]string{
+	"temp":     "temp",
+	"pressure": "pressure",
+	"length":   "rain",
+	"altitude": "altitude",
+	"speed":    "speed",
+}
+
+// The unit that each kind is displayed in for a system
+var systemUnits = map[string]map[string]string{
+	"temp":     {METRIC: "C", MIXED: "C", IMPERIAL: "F"},
+	"pressure": {METRIC: "hPa", MIXED: "inHg", IMPERIAL: "inHg"},
+	"rain":     {METRIC: "mm", MIXED: "in", IMPERIAL: "in"},
+	"speed":    {METRIC: "km/h", MIXED: "mph", IMPERIAL: "mph"},
+	"altitude": {METRIC: "m", MIXED: "ft", IMPERIAL: "ft"},
+}
+
+// The number of decimals that each unit is displayed with
+var precisions = map[string]int{
+	"C":    0,
+	"F":    0,
+	"hPa":  1,
+	"inHg": 2,
+	"mm":   1,
+	"in":   2,
+	"km/h": 0,
+	"mph":  0,
+	"m":    0,
+	"ft":   0,
+}
+
+// Get the kind of conversion that applies to a unit
+func Kind(unit string) string {
+	known, exists := knownUnits[unit]
+	if !exists {
+		return ""
+	}
+	return kinds[known.kind]
+}
+
+// Get the unit that a kind is displayed in for a system
+func SystemUnit(kind string, system string) string {
+	return systemUnits[kind][system]
+}
+
+// ToSystem converts a value to the unit of its kind in a system without
+// rounding it. Values that can't be converted keep their unit.
+func ToSystem(value float64, unit string, kind string, system string) (float64, string) {
+	target, exists := systemUnits[kind][system]
+	if exists && target != unit {
+		converted, err := Convert(value, unit, target)
+		if err == nil {
+			return converted, target
+		}
+	}
+	return value, unit
+}
+
+// ConvertSystem converts a value to the unit that it is displayed in for a
+// system, and rounds it for display. Values that can't be converted are only
+// rounded.
+func ConvertSystem(value float64, unit string, kind string, system string) (float64, string) {
+	value, unit = ToSystem(value, unit, kind, system)
+
+	if precision, exists := precisions[unit]; exists {
+		scale := math.Pow10(precision)
+		value = math.Round(value*scale) / scale
+	}
+	return value, unit
+}
@@ -0,0 +1,71 @@
+package units
+
+import (
+	"fmt"
+)
+
+type unit struct {
+	kind   string
+	scale  float64
+	offset float64
+}
+
+// Every unit is described as a linear transform to the base unit of its kind
+// (C, hPa, mm, m, km/h, deg, %).
+//
+// base = value * scale + offset
+var knownUnits = map[string]unit{
+	"C": {kind: "temp", scale: 1, offset: 0},
+	"F": {kind: "temp", scale: 5.0 / 9.0, offset: -32 * 5.0 / 9.0},
+	"K": {kind: "temp", scale: 1, offset: -273.15},
+
+	"hPa":  {kind: "pressure", scale: 1, offset: 0},
+	"mbar": {kind: "pressure", scale: 1, offset: 0},
+	"Pa":   {kind: "pressure", scale: 0.01, offset: 0},
+	"kPa":  {kind: "pressure", scale: 10, offset: 0},
+	"inHg": {kind: "pressure", scale: 33.86388666666671, offset: 0},
+	"mmHg": {kind: "pressure", scale: 1.3332236842105263, offset: 0},
+
+	"mm": {kind: "length", scale: 1, offset: 0},
+	"cm": {kind: "length", scale: 10, offset: 0},
+	"in": {kind: "length", scale: 25.4, offset: 0},
+
+	"m":  {kind: "altitude", scale: 1, offset: 0},
+	"ft": {kind: "altitude", scale: 0.3048, offset: 0},
+
+	"km/h":  {kind: "speed", scale: 1, offset: 0},
+	"m/s":   {kind: "speed", scale: 3.6, offset: 0},
+	"mph":   {kind: "speed", scale: 1.609344, offset: 0},
+	"knots": {kind: "speed", scale: 1.852, offset: 0},
+
+	"deg": {kind: "angle", scale: 1, offset: 0},
+	"%":   {kind: "ratio", scale: 1, offset: 0},
+}
+
+// Check whether a unit can be converted
+func IsKnown(name string) bool {
+	_, exists := knownUnits[name]
+	return exists
+}
+
+// Convert a value from one unit to another. Units that are the same are always
+// convertible, even if they are not known.
+func Convert(value float64, from string, to string) (float64, error) {
+	if from == to {
+		return value, nil
+	}
+	from_unit, exists := knownUnits[from]
+	if !exists {
+		return 0, fmt.Errorf("Unknown unit %v", from)
+	}
+	to_unit, exists := knownUnits[to]
+	if !exists {
+		return 0, fmt.Errorf("Unknown unit %v", to)
+	}
+	if from_unit.kind != to_unit.kind {
+		return 0, fmt.Errorf("Cannot convert %v to %v", from, to)
+	}
+
+	base := value*from_unit.scale + from_unit.offset
+	return (base - to_unit.offset) / to_unit.scale, nil
+}
@@ -0,0 +1,137 @@
+package units
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	for _, tc := range []struct {
+		value float64
+		from  string
+		to    string
+		want  float64
+	}{
+		{0, "C", "F", 32},
+		{100, "C", "F", 212},
+		{-40, "F", "C", -40},
+		{0, "C", "K", 273.15},
+		{29.92, "inHg", "hPa", 1013.2},
+		{1013.25, "hPa", "mbar", 1013.25},
+		{101325, "Pa", "hPa", 1013.25},
+		{101.325, "kPa", "hPa", 1013.25},
+		{760, "mmHg", "hPa", 1013.25},
+		{1, "in", "mm", 25.4},
+		{2.54, "cm", "in", 1},
+		{1000, "ft", "m", 304.8},
+		{10, "m/s", "km/h", 36},
+		{60, "mph", "km/h", 96.56},
+		{10, "knots", "mph", 11.51},
+		{5, "widgets", "widgets", 5},
+	} {
+		got, err := Convert(tc.value, tc.from, tc.to)
+		if err != nil {
+			t.Errorf("expected %v %v to convert to %v, got %v", tc.value, tc.from, tc.to, err)
+			continue
+		}
+		if math.Abs(got-tc.want) > 0.01 {
+			t.Errorf("expected %v %v to be %v %v, got %v", tc.value, tc.from, tc.want, tc.to, got)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	for _, tc := range []struct {
+		from string
+		to   string
+	}{
+		{"widgets", "C"},
+		{"C", "widgets"},
+		{"C", "hPa"},
+		{"in", "m"},
+		{"deg", "%"},
+	} {
+		if _, err := Convert(1, tc.from, tc.to); err == nil {
+			t.Errorf("expected %v to not convert to %v", tc.from, tc.to)
+		}
+	}
+}
+
+func TestKind(t *testing.T) {
+	for _, tc := range []struct {
+		unit  string
+		kind  string
+		known bool
+	}{
+		{"F", "temp", true},
+		{"mmHg", "pressure", true},
+		{"cm", "rain", true},
+		{"ft", "altitude", true},
+		{"knots", "speed", true},
+		{"deg", "", true},
+		{"%", "", true},
+		{"W/m^2", "", false},
+		{"", "", false},
+	} {
+		if kind := Kind(tc.unit); kind != tc.kind {
+			t.Errorf("expected %q to be a %q unit, got %q", tc.unit, tc.kind, kind)
+		}
+		if known := IsKnown(tc.unit); known != tc.known {
+			t.Errorf("expected %q to be known: %v", tc.unit, tc.known)
+		}
+	}
+}
+
+func TestToSystem(t *testing.T) {
+	for _, tc := range []struct {
+		value     float64
+		unit      string
+		system    string
+		want      float64
+		want_unit string
+	}{
+		{20.5, "C", METRIC, 20.5, "C"},
+		{20.5, "C", MIXED, 20.5, "C"},
+		{20.5, "C", IMPERIAL, 68.9, "F"},
+		{1013.25, "hPa", MIXED, 29.921, "inHg"},
+		{12.7, "mm", IMPERIAL, 0.5, "in"},
+		{0.5, "in", METRIC, 12.7, "mm"},
+		{16.09344, "km/h", IMPERIAL, 10, "mph"},
+		{304.8, "m", MIXED, 1000, "ft"},
+		{55, "%", IMPERIAL, 55, "%"},
+		{3, "widgets", IMPERIAL, 3, "widgets"},
+		{20.5, "C", "nautical", 20.5, "C"},
+	} {
+		got, unit := ToSystem(tc.value, tc.unit, Kind(tc.unit), tc.system)
+		if unit != tc.want_unit || math.Abs(got-tc.want) > 0.001 {
+			t.Errorf("expected %v %v in %v to be %v %v, got %v %v",
+				tc.value, tc.unit, tc.system, tc.want, tc.want_unit, got, unit)
+		}
+	}
+}
+
+func TestConvertSystem(t *testing.T) {
+	for _, tc := range []struct {
+		value     float64
+		unit      string
+		system    string
+		want      float64
+		want_unit string
+	}{
+		{20.46, "C", METRIC, 20, "C"},
+		{20.5, "C", IMPERIAL, 69, "F"},
+		{1013.27, "hPa", METRIC, 1013.3, "hPa"},
+		{1013.25, "hPa", IMPERIAL, 29.92, "inHg"},
+		{12.34, "mm", METRIC, 12.3, "mm"},
+		{12.7, "mm", MIXED, 0.5, "in"},
+		{16.09344, "km/h", MIXED, 10, "mph"},
+		// Units without a precision are not rounded
+		{55.55, "%", METRIC, 55.55, "%"},
+	} {
+		got, unit := ConvertSystem(tc.value, tc.unit, Kind(tc.unit), tc.system)
+		if unit != tc.want_unit || math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("expected %v %v in %v to be %v %v, got %v %v",
+				tc.value, tc.unit, tc.system, tc.want, tc.want_unit, got, unit)
+		}
+	}
+}
@@ -39,15 +39,16 @@ func (self *ChanMux[T]) main(source chan T) {
 	for _, c := range self.chans {
 		close(c)
 	}
+	self.chans = nil
 	self.closed = true
 }
 
 func (self *ChanMux[T]) Subscribe(buffer int) chan T {
+	self.Lock()
+	defer self.Unlock()
 	if self.closed {
 		return nil
 	}
-	self.Lock()
-	defer self.Unlock()
 
 	c := make(chan T, buffer)
 	self.chans = append(self.chans, c)
@@ -60,11 +61,11 @@ func (self *ChanMux[T]) Subscribe(buffer int) chan T {
 }
 
 func (self *ChanMux[T]) Unsubscribe(subscriber chan T) {
+	self.Lock()
+	defer self.Unlock()
 	if self.closed {
 		return
 	}
-	self.Lock()
-	defer self.Unlock()
 
 	for i, c := range self.chans {
 		if c == subscriber {
@@ -78,6 +79,15 @@ func (self *ChanMux[T]) Unsubscribe(subscriber chan T) {
 	}
 }
 
+// Get how many channels are subscribed
+func (self *ChanMux[T]) Len() int {
+	self.Lock()
+	defer self.Unlock()
+	return len(self.chans)
+}
+
 func (self *ChanMux[T]) IsClosed() bool {
+	self.Lock()
+	defer self.Unlock()
 	return self.closed
 }
@@ -1,31 +1,339 @@
 package util
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 
 	"github.com/BurntSushi/toml"
 )
 
+type StationConfig struct {
+	Id       string  `toml:"id"`
+	Name     string  `toml:"name"`
+	Location string  `toml:"location"`
+	Latitude float64 `toml:"latitude"`
+	// Meters above sea level, used to reduce the station pressure to sea level
+	Elevation *float64 `toml:"elevation"`
+	// The station is offline when it hasn't sent conditions for this long
+	StaleAfter Duration `toml:"stale_after"`
+	// The key that the station uploads conditions over http with
+	Key string `toml:"key"`
+}
+
+// Conditions older than After are reduced to one condition per Interval, or
+// deleted
+type RetentionConfig struct {
+	After    Duration `toml:"after"`
+	Interval Duration `toml:"interval"`
+	Delete   bool     `toml:"delete"`
+}
+
+// How a sensor is stored, and combined when conditions are reduced
+type SensorConfig struct {
+	Unit      string   `toml:"unit"`
+	Aggregate string   `toml:"aggregate"`
+	Companion string   `toml:"companion"`
+	Extremes  []string `toml:"extremes"`
+}
+
+// Fires when a sensor crosses a threshold for at least For
+type AlertConfig struct {
+	Name       string   `toml:"name"`
+	Station    string   `toml:"station"`
+	Sensor     string   `toml:"sensor"`
+	Compare    string   `toml:"compare"`
+	Threshold  float64  `toml:"threshold"`
+	Unit       string   `toml:"unit"`
+	For        Duration `toml:"for"`
+	Hysteresis float64  `toml:"hysteresis"`
+	QuietHours string   `toml:"quiet_hours"`
+	Notify     []string `toml:"notify"`
+}
+
+type WebhookConfig struct {
+	Name    string            `toml:"name"`
+	Url     string            `toml:"url"`
+	Headers map[string]string `toml:"headers"`
+}
+
+type EmailConfig struct {
+	Name     string   `toml:"name"`
+	Server   string   `toml:"server"`
+	Username string   `toml:"username"`
+	Password string   `toml:"password"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+}
+
+type NotifyConfig struct {
+	Webhooks []WebhookConfig `toml:"webhook"`
+	Email    []EmailConfig   `toml:"email"`
+}
+
+// Uploads the conditions of a station to a weather network (wunderground,
+// pwsweather, or cwop). Url is the endpoint, or the server of cwop.
+type UploadConfig struct {
+	Name      string   `toml:"name"`
+	Network   string   `toml:"network"`
+	Station   string   `toml:"station"`
+	Id        string   `toml:"id"`
+	Password  string   `toml:"password"`
+	Url       string   `toml:"url"`
+	Interval  Duration `toml:"interval"`
+	Queue     int      `toml:"queue"`
+	Latitude  float64  `toml:"latitude"`
+	Longitude float64  `toml:"longitude"`
+}
+
+// How the mqtt server is connected to
+type MqttConfig struct {
+	Username           string   `toml:"username"`
+	Password           string   `toml:"password"`
+	CaFile             string   `toml:"ca_file"`
+	CertFile           string   `toml:"cert_file"`
+	KeyFile            string   `toml:"key_file"`
+	InsecureSkipVerify bool     `toml:"insecure_skip_verify"`
+	Keepalive          Duration `toml:"keepalive"`
+	CleanSession       *bool    `toml:"clean_session"`
+	Qos                byte     `toml:"qos"`
+}
+
+// An mqtt broker run inside the app, which the stations connect to
+type BrokerConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	Listen   string `toml:"listen"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+}
+
+// Publishes the sensors of every station to Home Assistant over mqtt
+type HomeAssistantConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Prefix  string `toml:"discovery_prefix"`
+	Topic   string `toml:"topic"`
+}
+
+// When the app is ready to serve
+type HealthConfig struct {
+	// The last message of every station has to be newer than this, which is
+	// stale_after of the station by default
+	MaxAge Duration `toml:"max_age"`
+}
+
+// The admin endpoints, which are only served with a token
+type AdminConfig struct {
+	Token string `toml:"token"`
+}
+
 type Config struct {
-	Base       string `toml:"base"`
-	Db         string `toml:"db"`
-	Listen     string `toml:"listen"`
-	MqttServer string `toml:"mqtt_server"`
-	MqttId     string `toml:"mqtt_id"`
-	StationId  string `toml:"station_id"`
+	Base          string                  `toml:"base"`
+	Db            string                  `toml:"db"`
+	Listen        string                  `toml:"listen"`
+	MqttServer    string                  `toml:"mqtt_server"`
+	MqttId        string                  `toml:"mqtt_id"`
+	Mqtt          MqttConfig              `toml:"mqtt"`
+	Broker        BrokerConfig            `toml:"broker"`
+	StationId     string                  `toml:"station_id"`
+	Stations      []StationConfig         `toml:"stations"`
+	Retention     []RetentionConfig       `toml:"retention"`
+	Sensors       map[string]SensorConfig `toml:"sensors"`
+	Alerts        []AlertConfig           `toml:"alerts"`
+	Notify        NotifyConfig            `toml:"notify"`
+	Uploads       []UploadConfig          `toml:"uploads"`
+	HomeAssistant HomeAssistantConfig     `toml:"homeassistant"`
+	Health        HealthConfig            `toml:"health"`
+	Admin         AdminConfig             `toml:"admin"`
+}
+
+// The values of the settings that aren't in the config
+var DefaultConfig = Config{
+	Listen:     ":8080",
+	Db:         "db.sqlite3",
+	MqttServer: "tcp://localhost:1883",
+	MqttId:     "station-webapp",
+	Broker: BrokerConfig{
+		Listen: ":1883",
+	},
 }
 
-var Conf Config
+var current atomic.Pointer[Config]
 
+// Conf gets the config that the app is running with. A reload replaces the
+// whole config, so get it once for settings that are read together. Configs
+// must not be changed once they are in use.
+func Conf() *Config {
+	if conf := current.Load(); conf != nil {
+		return conf
+	}
+	return &Config{}
+}
+
+// SetConf replaces the config that the app is running with
+func SetConf(conf *Config) {
+	current.Store(conf)
+}
+
+// Load a config and run the app with it
 func LoadConfig(path string) (*Config, error) {
+	conf, err := ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	SetConf(conf)
+	return conf, nil
+}
+
+// Read a config over the defaults and STATION_* environment variables over the
+// config, making sure that it is valid
+func ReadConfig(path string) (*Config, error) {
 	contents, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	err = toml.Unmarshal(contents, &Conf)
+	conf := DefaultConfig
+	meta, err := toml.Decode(string(contents), &conf)
 	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("%v: unknown settings %v", path, strings.Join(keys, ", "))
+	}
+
+	if err := applyEnvironment(&conf, os.Environ()); err != nil {
 		return nil, err
 	}
-	return &Conf, nil
+
+	// station_id configures a single station for older configs
+	if len(conf.Stations) == 0 && conf.StationId != "" {
+		conf.Stations = []StationConfig{{Id: conf.StationId}}
+	}
+	for i := range conf.Stations {
+		if conf.Stations[i].Name == "" {
+			conf.Stations[i].Name = conf.Stations[i].Id
+		}
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	return &conf, nil
+}
+
+// The schemes that the mqtt client can connect with
+var mqttSchemes = []string{"tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss", "unix"}
+
+// Check that an address can be listened on
+func checkListen(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check that the database can be written to, or created if it doesn't exist
+func checkWritable(path string) error {
+	if path == ":memory:" || strings.HasPrefix(path, "file:") {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		return f.Close()
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err = os.CreateTemp(filepath.Dir(path), ".station-webapp-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Validate checks the settings that would otherwise fail once the app is
+// running, and normalizes base to either be empty or start without ending in
+// a slash.
+func (self *Config) Validate() error {
+	if err := checkListen(self.Listen); err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", self.Listen, err)
+	}
+
+	base, err := url.Parse(self.Base)
+	if err != nil || base.Host != "" || base.RawQuery != "" || base.Fragment != "" {
+		return fmt.Errorf("invalid base %q: expected a path such as /my-app", self.Base)
+	}
+	self.Base = strings.TrimRight(self.Base, "/")
+	if self.Base != "" && !strings.HasPrefix(self.Base, "/") {
+		self.Base = "/" + self.Base
+	}
+
+	if self.Db == "" {
+		return fmt.Errorf("db is empty")
+	}
+	if err := checkWritable(self.Db); err != nil {
+		return fmt.Errorf("the database %v can't be written to: %w", self.Db, err)
+	}
+
+	if self.Broker.Enabled {
+		if err := checkListen(self.Broker.Listen); err != nil {
+			return fmt.Errorf("invalid broker listen address %q: %w", self.Broker.Listen, err)
+		}
+	} else {
+		server, err := url.Parse(self.MqttServer)
+		if err != nil {
+			return fmt.Errorf("invalid mqtt_server %q: %w", self.MqttServer, err)
+		}
+		if !slices.Contains(mqttSchemes, server.Scheme) {
+			return fmt.Errorf(
+				"invalid mqtt_server %q: the scheme must be one of %v",
+				self.MqttServer, strings.Join(mqttSchemes, ", "),
+			)
+		}
+	}
+	if self.MqttId == "" {
+		return fmt.Errorf("mqtt_id is empty")
+	}
+	if self.Mqtt.Qos > 2 {
+		return fmt.Errorf("invalid mqtt qos %v: expected 0, 1, or 2", self.Mqtt.Qos)
+	}
+
+	ids := make(map[string]bool)
+	for i, station := range self.Stations {
+		if station.Id == "" {
+			return fmt.Errorf("station %v needs an id", i+1)
+		}
+		if ids[station.Id] {
+			return fmt.Errorf("station %v is configured more than once", station.Id)
+		}
+		ids[station.Id] = true
+		if station.StaleAfter.Duration < 0 {
+			return fmt.Errorf("station %v: stale_after must be positive", station.Id)
+		}
+		if station.Elevation != nil && (*station.Elevation < -500 || *station.Elevation > 9000) {
+			return fmt.Errorf("station %v: elevation %v is not in meters above sea level", station.Id, *station.Elevation)
+		}
+	}
+	if self.Health.MaxAge.Duration < 0 {
+		return fmt.Errorf("health max_age must be positive")
+	}
+
+	return nil
 }
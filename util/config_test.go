@@ -0,0 +1,250 @@
+package util
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func float(value float64) *float64 {
+	return &value
+}
+
+// A valid config to change in each test
+func validConfig(t *testing.T) Config {
+	conf := DefaultConfig
+	conf.Db = t.TempDir() + "/db.sqlite3"
+	conf.Stations = []StationConfig{{Id: "roof", Name: "Rooftop"}}
+	return conf
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		valid  bool
+	}{
+		{"default", func(conf *Config) {}, true},
+		{"listen on a host", func(conf *Config) { conf.Listen = "localhost:8080" }, true},
+		{"listen without a port", func(conf *Config) { conf.Listen = "localhost" }, false},
+		{"listen on an unknown port", func(conf *Config) { conf.Listen = ":http-ish" }, false},
+		{"base with a host", func(conf *Config) { conf.Base = "http://example.com/app" }, false},
+		{"base with a query", func(conf *Config) { conf.Base = "/app?x=1" }, false},
+		{"no db", func(conf *Config) { conf.Db = "" }, false},
+		{"db in memory", func(conf *Config) { conf.Db = ":memory:" }, true},
+		{"db in a missing directory", func(conf *Config) { conf.Db = t.TempDir() + "/missing/db.sqlite3" }, false},
+		{"mqtt over tls", func(conf *Config) { conf.MqttServer = "ssl://broker.example.com:8883" }, true},
+		{"mqtt over http", func(conf *Config) { conf.MqttServer = "http://broker.example.com" }, false},
+		{"mqtt without a scheme", func(conf *Config) { conf.MqttServer = "localhost:1883" }, false},
+		{"broker", func(conf *Config) {
+			conf.Broker.Enabled = true
+			conf.MqttServer = "localhost:1883"
+		}, true},
+		{"broker without a port", func(conf *Config) {
+			conf.Broker.Enabled = true
+			conf.Broker.Listen = "localhost"
+		}, false},
+		{"no mqtt id", func(conf *Config) { conf.MqttId = "" }, false},
+		{"qos", func(conf *Config) { conf.Mqtt.Qos = 2 }, true},
+		{"invalid qos", func(conf *Config) { conf.Mqtt.Qos = 3 }, false},
+		{"no stations", func(conf *Config) { conf.Stations = nil }, true},
+		{"station without an id", func(conf *Config) { conf.Stations = append(conf.Stations, StationConfig{}) }, false},
+		{"same station twice", func(conf *Config) {
+			conf.Stations = append(conf.Stations, StationConfig{Id: "roof"})
+		}, false},
+		{"negative stale after", func(conf *Config) {
+			conf.Stations[0].StaleAfter.Duration = -time.Minute
+		}, false},
+		{"elevation", func(conf *Config) { conf.Stations[0].Elevation = float(1400) }, true},
+		{"below sea level", func(conf *Config) { conf.Stations[0].Elevation = float(-30) }, true},
+		{"elevation in feet", func(conf *Config) { conf.Stations[0].Elevation = float(14000) }, false},
+		{"negative max age", func(conf *Config) { conf.Health.MaxAge.Duration = -time.Minute }, false},
+	} {
+		conf := validConfig(t)
+		tc.change(&conf)
+		if err := conf.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%v: expected valid %v, got %v", tc.name, tc.valid, err)
+		}
+	}
+}
+
+func TestValidateBase(t *testing.T) {
+	for _, tc := range []struct {
+		base string
+		want string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"/app", "/app"},
+		{"/app/", "/app"},
+		{"app", "/app"},
+		{"app/weather//", "/app/weather"},
+	} {
+		conf := validConfig(t)
+		conf.Base = tc.base
+		if err := conf.Validate(); err != nil {
+			t.Errorf("expected %q to be valid, got %v", tc.base, err)
+			continue
+		}
+		if conf.Base != tc.want {
+			t.Errorf("expected %q to be normalized to %q, got %q", tc.base, tc.want, conf.Base)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := t.TempDir() + "/config.toml"
+	contents := strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	db := t.TempDir() + "/db.sqlite3"
+	path := writeConfig(t,
+		`db = "`+db+`"`,
+		`base = "weather/"`,
+		`[[stations]]`,
+		`id = "roof"`,
+		`elevation = 1400`,
+		`stale_after = "1d"`,
+		`[[stations]]`,
+		`id = "garden"`,
+		`name = "Garden"`,
+	)
+	t.Setenv("STATION_LISTEN", ":9090")
+
+	conf, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Listen != ":9090" {
+		t.Errorf("expected the environment to override listen, got %v", conf.Listen)
+	}
+	if conf.MqttId != DefaultConfig.MqttId {
+		t.Errorf("expected the default mqtt id, got %v", conf.MqttId)
+	}
+	if conf.Base != "/weather" {
+		t.Errorf("expected the base to be normalized, got %v", conf.Base)
+	}
+	if len(conf.Stations) != 2 {
+		t.Fatalf("expected 2 stations, got %v", len(conf.Stations))
+	}
+	roof := conf.Stations[0]
+	if roof.Name != "roof" || roof.Elevation == nil || *roof.Elevation != 1400 || roof.StaleAfter.Duration != time.Hour*24 {
+		t.Errorf("unexpected station %+v", roof)
+	}
+	if conf.Stations[1].Name != "Garden" || conf.Stations[1].Elevation != nil {
+		t.Errorf("unexpected station %+v", conf.Stations[1])
+	}
+}
+
+func TestReadConfigStationId(t *testing.T) {
+	path := writeConfig(t, `db = ":memory:"`, `station_id = "roof"`)
+	conf, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Stations) != 1 || conf.Stations[0].Id != "roof" || conf.Stations[0].Name != "roof" {
+		t.Errorf("expected station_id to configure one station, got %+v", conf.Stations)
+	}
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		lines []string
+		env   string
+	}{
+		{"syntax", []string{`db = `}, ""},
+		{"unknown setting", []string{`db = ":memory:"`, `mqtt_servre = "tcp://localhost:1883"`}, ""},
+		{"unknown station setting", []string{`db = ":memory:"`, `[[stations]]`, `id = "roof"`, `altitude = 1400`}, ""},
+		{"invalid", []string{`db = ":memory:"`, `[mqtt]`, `qos = 3`}, ""},
+		{"invalid environment", []string{`db = ":memory:"`}, "STATION_MQTT_QOS=three"},
+	} {
+		if tc.env != "" {
+			name, value, _ := strings.Cut(tc.env, "=")
+			t.Setenv(name, value)
+		}
+		if _, err := ReadConfig(writeConfig(t, tc.lines...)); err == nil {
+			t.Errorf("%v: expected an error", tc.name)
+		}
+	}
+
+	if _, err := ReadConfig(t.TempDir() + "/missing.toml"); err == nil {
+		t.Error("expected an error for a missing config")
+	}
+}
+
+func TestApplyEnvironment(t *testing.T) {
+	conf := DefaultConfig
+	err := applyEnvironment(&conf, []string{
+		"STATION_MQTT_SERVER=ssl://broker.example.com:8883",
+		"STATION_MQTT_USERNAME=weather",
+		"STATION_MQTT_INSECURE_SKIP_VERIFY=true",
+		"STATION_MQTT_KEEPALIVE=45s",
+		"STATION_MQTT_CLEAN_SESSION=false",
+		"STATION_MQTT_QOS=1",
+		"STATION_BROKER_ENABLED=1",
+		"STATION_HEALTH_MAX_AGE=2h",
+		"STATION_UNKNOWN=ignored",
+		"HOME=/root",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MqttServer != "ssl://broker.example.com:8883" || conf.Mqtt.Username != "weather" {
+		t.Errorf("expected the mqtt server and username to be set, got %v %v", conf.MqttServer, conf.Mqtt.Username)
+	}
+	if !conf.Mqtt.InsecureSkipVerify || !conf.Broker.Enabled {
+		t.Error("expected the booleans to be set")
+	}
+	if conf.Mqtt.Keepalive.Duration != time.Second*45 || conf.Health.MaxAge.Duration != time.Hour*2 {
+		t.Errorf("expected the durations to be set, got %v %v", conf.Mqtt.Keepalive, conf.Health.MaxAge)
+	}
+	if conf.Mqtt.CleanSession == nil || *conf.Mqtt.CleanSession {
+		t.Errorf("expected clean_session to be false, got %v", conf.Mqtt.CleanSession)
+	}
+	if conf.Mqtt.Qos != 1 {
+		t.Errorf("expected a qos of 1, got %v", conf.Mqtt.Qos)
+	}
+
+	for _, invalid := range []string{
+		"STATION_BROKER_ENABLED=maybe",
+		"STATION_MQTT_QOS=256",
+		"STATION_MQTT_KEEPALIVE=soon",
+		"STATION_MQTT_CLEAN_SESSION=sometimes",
+	} {
+		conf := DefaultConfig
+		if err := applyEnvironment(&conf, []string{invalid}); err == nil {
+			t.Errorf("expected %v to be invalid", invalid)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+		valid bool
+	}{
+		{"90m", time.Minute * 90, true},
+		{"1h30m", time.Minute * 90, true},
+		{"30d", time.Hour * 24 * 30, true},
+		{"1.5d", time.Hour * 36, true},
+		{"2w", time.Hour * 24 * 14, true},
+		{"1y", time.Hour * 24 * 365, true},
+		{"d", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	} {
+		got, err := ParseDuration(tc.value)
+		if (err == nil) != tc.valid || got != tc.want {
+			t.Errorf("expected %q to be %v (valid %v), got %v (%v)", tc.value, tc.want, tc.valid, got, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var longUnits = map[string]time.Duration{
+	"d": time.Hour * 24,
+	"w": time.Hour * 24 * 7,
+	"y": time.Hour * 24 * 365,
+}
+
+// Parse a duration, which may also be given in days, weeks, or years such as
+// "30d" or "2y"
+func ParseDuration(value string) (time.Duration, error) {
+	for suffix, unit := range longUnits {
+		if number, found := strings.CutSuffix(value, suffix); found {
+			count, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, fmt.Errorf("Invalid duration %v", value)
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(value)
+}
+
+// Duration can be unmarshaled from a toml string
+type Duration struct {
+	time.Duration
+}
+
+func (self *Duration) UnmarshalText(text []byte) error {
+	duration, err := ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	self.Duration = duration
+	return nil
+}
+
+func (self Duration) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const ENV_PREFIX = "STATION_"
+
+// Get the environment variable of every setting that isn't a list or table,
+// such as STATION_MQTT_SERVER for mqtt_server or STATION_MQTT_USERNAME for
+// username of [mqtt]
+func envSettings(value reflect.Value, prefix string, settings map[string]reflect.Value) {
+	textUnmarshaler := reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := field.Tag.Get("toml")
+		if key == "" {
+			continue
+		}
+		name := prefix + strings.ToUpper(key)
+		v := value.Field(i)
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Map {
+			continue
+		}
+		if v.Kind() == reflect.Struct && !reflect.PointerTo(v.Type()).Implements(textUnmarshaler) {
+			envSettings(v, name+"_", settings)
+			continue
+		}
+		settings[name] = v
+	}
+}
+
+// Set a setting from the value of its environment variable
+func setEnv(v reflect.Value, value string) error {
+	if v.Kind() == reflect.Pointer {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setEnv(ptr.Elem(), value); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+	if unmarshaler, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(parsed)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(parsed)
+	case reflect.Uint8:
+		parsed, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return err
+		}
+		v.SetUint(parsed)
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(parsed)
+	default:
+		return fmt.Errorf("can't be set from the environment")
+	}
+	return nil
+}
+
+// Override the settings of a config with STATION_* environment variables.
+// Lists and tables, such as the stations, can only be set in the config.
+func applyEnvironment(conf *Config, environ []string) error {
+	settings := make(map[string]reflect.Value)
+	envSettings(reflect.ValueOf(conf).Elem(), ENV_PREFIX, settings)
+
+	for _, env := range environ {
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, ENV_PREFIX) {
+			continue
+		}
+		setting, exists := settings[name]
+		if !exists {
+			logrus.Warnf("Ignoring %v, which isn't a setting", name)
+			continue
+		}
+		if err := setEnv(setting, value); err != nil {
+			return fmt.Errorf("invalid %v %q: %w", name, value, err)
+		}
+	}
+	return nil
+}
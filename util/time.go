@@ -0,0 +1,12 @@
+package util
+
+import "time"
+
+// Parse a time given by a user, either as RFC3339 or as a local date
+func ParseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, time.Local)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Get the size of the database, including its write-ahead log
+func databaseSize(path string) int64 {
+	var size int64
+	for _, file := range []string{path, path + "-wal"} {
+		if info, err := os.Stat(file); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func runVacuum(args []string) error {
+	flags := flag.NewFlagSet("vacuum", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %v vacuum [config.toml]\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "\nStop the web app first, vacuuming needs the database to itself.\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	conf, db, err := openDatabase(configPath(flags))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	before := databaseSize(conf.Db)
+	start := time.Now()
+	if _, err := db.Exec(`VACUUM;`); err != nil {
+		return err
+	}
+	after := databaseSize(conf.Db)
+
+	fmt.Printf(
+		"Vacuumed %v in %v: %v KiB -> %v KiB\n",
+		conf.Db, time.Since(start).Round(time.Millisecond), before/1024, after/1024,
+	)
+	return nil
+}
@@ -0,0 +1,123 @@
+package weather
+
+import "math"
+
+// The sensors that are derived from the readings of a station
+const HEAT_INDEX = "heatindex"
+const WIND_CHILL = "windchill"
+const HUMIDEX = "humidex"
+const APPARENT_TEMP = "apparent-temp"
+const CLOUD_BASE = "cloudbase"
+
+// Every derived sensor is computed from readings in their stored units: C, %,
+// and km/h. Cloud base is in meters above the station.
+
+func cToF(c float64) float64 {
+	return c*9/5 + 32
+}
+
+func fToC(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+// Vapor pressure in hPa (Magnus formula)
+func vaporPressure(temp float64) float64 {
+	return 6.112 * math.Exp(17.62*temp/(243.12+temp))
+}
+
+// Dewpoint from the temperature and relative humidity (Magnus formula)
+func Dewpoint(temp float64, humidity float64) float64 {
+	gamma := math.Log(humidity/100) + 17.62*temp/(243.12+temp)
+	return 243.12 * gamma / (17.62 - gamma)
+}
+
+// Heat index using the NWS regression by Rothfusz, with its adjustments for
+// low and high humidity
+func HeatIndex(temp float64, humidity float64) float64 {
+	t := cToF(temp)
+	rh := humidity
+
+	simple := 0.5 * (t + 61 + (t-68)*1.2 + rh*0.094)
+	if (simple+t)/2 < 80 {
+		return fToC(simple)
+	}
+
+	hi := -42.379 + 2.04901523*t + 10.14333127*rh -
+		0.22475541*t*rh - 0.00683783*t*t - 0.05481717*rh*rh +
+		0.00122874*t*t*rh + 0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+
+	if rh < 13 && t >= 80 && t <= 112 {
+		hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+	} else if rh > 85 && t >= 80 && t <= 87 {
+		hi += (rh - 85) / 10 * (87 - t) / 5
+	}
+	return fToC(hi)
+}
+
+// Wind chill using the 2001 NWS/MSC formula. Outside of the conditions where
+// wind chill is defined, it is the temperature.
+func WindChill(temp float64, wind float64) float64 {
+	if temp > 10 || wind <= 4.8 {
+		return temp
+	}
+	v := math.Pow(wind, 0.16)
+	return 13.12 + 0.6215*temp - 11.37*v + 0.3965*temp*v
+}
+
+// Humidex as used by Environment Canada
+func Humidex(temp float64, dewpoint float64) float64 {
+	e := 6.11 * math.Exp(5417.7530*(1/273.16-1/(273.15+dewpoint)))
+	return temp + 0.5555*(e-10)
+}
+
+// Apparent temperature from Steadman, as used by the Australian Bureau of
+// Meteorology, without solar radiation
+func ApparentTemperature(temp float64, humidity float64, wind float64) float64 {
+	e := humidity / 100 * vaporPressure(temp)
+	return temp + 0.33*e - 0.70*(wind/3.6) - 4.00
+}
+
+// Estimate the height of the cloud base from the spread between the
+// temperature and dewpoint
+func CloudBase(temp float64, dewpoint float64) float64 {
+	return math.Max(0, (temp-dewpoint)*125)
+}
+
+// Derive adds the derived sensors to a set of readings. Sensors that the
+// station already reports, or that can't be computed with the readings
+// available, are left alone.
+func Derive(sensors map[string]float64) {
+	set := func(name string, value float64) {
+		if _, exists := sensors[name]; !exists && !math.IsNaN(value) && !math.IsInf(value, 0) {
+			sensors[name] = value
+		}
+	}
+
+	temp, has_temp := sensors["temp"]
+	if !has_temp {
+		return
+	}
+	humidity, has_humidity := sensors["humidity"]
+	dewpoint, has_dewpoint := sensors["dewpoint"]
+	if !has_dewpoint && has_humidity && humidity > 0 {
+		dewpoint, has_dewpoint = Dewpoint(temp, humidity), true
+	}
+	wind, has_wind := sensors["windspd-avg2m"]
+	if !has_wind {
+		wind, has_wind = sensors["windspd"]
+	}
+
+	if has_humidity {
+		set(HEAT_INDEX, HeatIndex(temp, humidity))
+	}
+	if has_wind {
+		set(WIND_CHILL, WindChill(temp, wind))
+	}
+	if has_dewpoint {
+		set(HUMIDEX, Humidex(temp, dewpoint))
+		set(CLOUD_BASE, CloudBase(temp, dewpoint))
+	}
+	if has_humidity && has_wind {
+		set(APPARENT_TEMP, ApparentTemperature(temp, humidity, wind))
+	}
+}
@@ -0,0 +1,171 @@
+package weather
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDewpoint(t *testing.T) {
+	for _, tc := range []struct {
+		temp     float64
+		humidity float64
+		want     float64
+	}{
+		{20, 50, 9.3},
+		{25, 100, 25},
+		{30, 70, 23.9},
+		{-5, 80, -7.9},
+	} {
+		if got := Dewpoint(tc.temp, tc.humidity); !near(got, tc.want, 0.1) {
+			t.Errorf("expected the dewpoint at %v C and %v%% to be %v, got %v", tc.temp, tc.humidity, tc.want, got)
+		}
+	}
+}
+
+// The heat index is compared against the NWS heat index chart, in F
+func TestHeatIndex(t *testing.T) {
+	for _, tc := range []struct {
+		temp     float64
+		humidity float64
+		want     float64
+	}{
+		{70, 50, 69},
+		{80, 40, 80},
+		{90, 50, 95},
+		{90, 70, 106},
+		{100, 40, 109},
+		// Adjusted for low and high humidity
+		{100, 10, 94},
+		{84, 90, 98},
+	} {
+		if got := cToF(HeatIndex(fToC(tc.temp), tc.humidity)); !near(got, tc.want, 1) {
+			t.Errorf("expected the heat index at %v F and %v%% to be %v F, got %v", tc.temp, tc.humidity, tc.want, got)
+		}
+	}
+}
+
+// The wind chill is compared against the Environment Canada wind chill chart
+func TestWindChill(t *testing.T) {
+	for _, tc := range []struct {
+		temp float64
+		wind float64
+		want float64
+	}{
+		{-10, 20, -18},
+		{-20, 40, -34},
+		{0, 10, -3},
+		{5, 50, -1},
+		// Wind chill isn't defined when it is warm or calm
+		{15, 30, 15},
+		{-5, 3, -5},
+	} {
+		if got := WindChill(tc.temp, tc.wind); !near(got, tc.want, 0.5) {
+			t.Errorf("expected the wind chill at %v C and %v km/h to be %v, got %v", tc.temp, tc.wind, tc.want, got)
+		}
+	}
+}
+
+// The humidex is compared against the Environment Canada humidex chart
+func TestHumidex(t *testing.T) {
+	for _, tc := range []struct {
+		temp     float64
+		dewpoint float64
+		want     float64
+	}{
+		{30, 15, 34},
+		{35, 25, 47},
+		{25, 10, 26},
+	} {
+		if got := Humidex(tc.temp, tc.dewpoint); !near(got, tc.want, 0.5) {
+			t.Errorf("expected the humidex at %v C and a dewpoint of %v to be %v, got %v", tc.temp, tc.dewpoint, tc.want, got)
+		}
+	}
+}
+
+func TestApparentTemperature(t *testing.T) {
+	for _, tc := range []struct {
+		temp     float64
+		humidity float64
+		wind     float64
+		want     float64
+	}{
+		{25, 50, 0, 26.2},
+		{10, 60, 36, 1.4},
+		{35, 20, 18, 31.2},
+	} {
+		if got := ApparentTemperature(tc.temp, tc.humidity, tc.wind); !near(got, tc.want, 0.1) {
+			t.Errorf("expected the apparent temperature at %v C, %v%%, and %v km/h to be %v, got %v",
+				tc.temp, tc.humidity, tc.wind, tc.want, got)
+		}
+	}
+}
+
+func TestCloudBase(t *testing.T) {
+	for _, tc := range []struct {
+		temp     float64
+		dewpoint float64
+		want     float64
+	}{
+		{20, 10, 1250},
+		{15, 15, 0},
+		{15, 16, 0},
+	} {
+		if got := CloudBase(tc.temp, tc.dewpoint); got != tc.want {
+			t.Errorf("expected the cloud base at %v C and a dewpoint of %v to be %v, got %v", tc.temp, tc.dewpoint, tc.want, got)
+		}
+	}
+}
+
+func TestDerive(t *testing.T) {
+	all := []string{HEAT_INDEX, WIND_CHILL, HUMIDEX, APPARENT_TEMP, CLOUD_BASE}
+	for _, tc := range []struct {
+		name    string
+		sensors map[string]float64
+		derived []string
+	}{
+		{"no temperature", map[string]float64{"humidity": 50, "windspd": 10}, nil},
+		{"temperature", map[string]float64{"temp": 20}, nil},
+		{"humidity", map[string]float64{"temp": 20, "humidity": 50}, []string{HEAT_INDEX, HUMIDEX, CLOUD_BASE}},
+		{"dewpoint", map[string]float64{"temp": 20, "dewpoint": 10}, []string{HUMIDEX, CLOUD_BASE}},
+		{"wind", map[string]float64{"temp": 20, "windspd": 10}, []string{WIND_CHILL}},
+		{"averaged wind", map[string]float64{"temp": 20, "windspd-avg2m": 10}, []string{WIND_CHILL}},
+		{"everything", map[string]float64{"temp": 20, "humidity": 50, "windspd": 10}, all},
+		// A dewpoint can't be computed without any humidity
+		{"dry", map[string]float64{"temp": 20, "humidity": 0, "windspd": 10}, []string{HEAT_INDEX, WIND_CHILL, APPARENT_TEMP}},
+	} {
+		Derive(tc.sensors)
+		derived := map[string]bool{}
+		for _, sensor := range tc.derived {
+			derived[sensor] = true
+		}
+		for _, sensor := range all {
+			if _, exists := tc.sensors[sensor]; exists != derived[sensor] {
+				t.Errorf("%v: expected %v to be derived: %v", tc.name, sensor, derived[sensor])
+			}
+		}
+	}
+}
+
+func TestDeriveKeepsReportedSensors(t *testing.T) {
+	sensors := map[string]float64{"temp": 30, "humidity": 70, "dewpoint": 15, "windspd": 30, "windspd-avg2m": 10, HEAT_INDEX: 40}
+	Derive(sensors)
+
+	for _, tc := range []struct {
+		sensor string
+		want   float64
+	}{
+		{HEAT_INDEX, 40},
+		// The reported dewpoint is used over the humidity
+		{HUMIDEX, Humidex(30, 15)},
+		// The averaged wind is used over the current wind
+		{APPARENT_TEMP, ApparentTemperature(30, 70, 10)},
+		{WIND_CHILL, 30},
+	} {
+		if got := sensors[tc.sensor]; math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("expected %v to be %v, got %v", tc.sensor, tc.want, got)
+		}
+	}
+	if _, exists := sensors["dewpoint"]; !exists {
+		t.Error("expected the dewpoint to be kept")
+	}
+}
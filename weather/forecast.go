@@ -0,0 +1,213 @@
+package weather
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+// The pressure tendency is measured over this window
+const TENDENCY_WINDOW = time.Hour * 3
+
+// At least this much of the window is needed to tell the tendency
+const TENDENCY_MIN_SPAN = time.Hour
+
+type Forecast struct {
+	Time time.Time
+	// The pressure at sea level in hPa
+	Pressure float64
+	// The change in pressure over 3 hours in hPa
+	Change   float64
+	Tendency string
+	Letter   string
+	Text     string
+}
+
+// Tendency describes a change in pressure over 3 hours in hPa, following the
+// terms used in WMO shipping forecasts.
+func Tendency(change float64) string {
+	direction := "Rising"
+	if change < 0 {
+		direction = "Falling"
+	}
+	change = math.Abs(change)
+	switch {
+	case change < 0.1:
+		return "Steady"
+	case change < 1.6:
+		return direction + " slowly"
+	case change < 3.6:
+		return direction
+	case change < 6.1:
+		return direction + " quickly"
+	}
+	return direction + " very rapidly"
+}
+
+var zambrettiText = []string{
+	"Settled fine",
+	"Fine weather",
+	"Becoming fine",
+	"Fine, becoming less settled",
+	"Fine, possible showers",
+	"Fairly fine, improving",
+	"Fairly fine, possible showers early",
+	"Fairly fine, showery later",
+	"Showery early, improving",
+	"Changeable, mending",
+	"Fairly fine, showers likely",
+	"Rather unsettled clearing later",
+	"Unsettled, probably improving",
+	"Showery, bright intervals",
+	"Showery, becoming less settled",
+	"Changeable, some rain",
+	"Unsettled, short fine intervals",
+	"Unsettled, rain later",
+	"Unsettled, some rain",
+	"Mostly very unsettled",
+	"Occasional rain, worsening",
+	"Rain at times, very unsettled",
+	"Rain at frequent intervals",
+	"Rain, very unsettled",
+	"Stormy, may improve",
+	"Stormy, much rain",
+}
+
+// The forecast for each step of pressure between 950 and 1050 hPa
+var zambrettiRising = []int{25, 25, 25, 24, 24, 19, 16, 12, 11, 9, 8, 6, 5, 2, 1, 1, 0, 0, 0, 0, 0, 0}
+var zambrettiSteady = []int{25, 25, 25, 25, 25, 25, 23, 23, 22, 18, 15, 13, 10, 4, 1, 1, 0, 0, 0, 0, 0, 0}
+var zambrettiFalling = []int{25, 25, 25, 25, 25, 25, 25, 25, 23, 23, 21, 20, 17, 14, 7, 3, 1, 1, 1, 0, 0, 0}
+
+// How much the wind direction shifts the pressure, in hPa, starting at north
+// and going clockwise in 16 points
+var zambrettiWind = []float64{6, 5, 5, 2, -0.5, -2, -5, -8.5, -12, -10, -6, -4.5, -3, -0.5, 1.5, 3}
+
+const zambrettiBottom = 950.0
+const zambrettiTop = 1050.0
+
+// Zambretti forecasts the weather from the sea level pressure in hPa, the
+// change over 3 hours, and the wind direction in degrees (NaN if calm or
+// unknown). The southern hemisphere has its wind and seasons reversed.
+func Zambretti(pressure float64, change float64, wind float64, month time.Month, southern bool) (string, string) {
+	if !math.IsNaN(wind) {
+		if southern {
+			wind += 180
+		}
+		point := int(math.Round(math.Mod(wind, 360)/22.5)) % 16
+		pressure += zambrettiWind[point]
+	}
+
+	summer := month >= time.April && month <= time.September
+	if southern {
+		summer = !summer
+	}
+
+	options := zambrettiSteady
+	if change >= 1.6 {
+		options = zambrettiRising
+		if summer {
+			pressure += 7
+		}
+	} else if change <= -1.6 {
+		options = zambrettiFalling
+		if !summer {
+			pressure -= 7
+		}
+	}
+
+	step := (zambrettiTop - zambrettiBottom) / float64(len(options))
+	option := int(math.Floor((pressure - zambrettiBottom) / step))
+	option = max(0, min(option, len(options)-1))
+
+	index := options[option]
+	return string(rune('A' + index)), zambrettiText[index]
+}
+
+// The standard lapse rate of the atmosphere in K/m
+const LAPSE_RATE = 0.0065
+
+// SeaLevelPressure reduces the pressure in hPa at a station to sea level with
+// the hypsometric equation, using the temperature of the station in C to
+// estimate the mean temperature of the air below it. The standard atmosphere
+// is assumed when the temperature is NaN.
+func SeaLevelPressure(pressure float64, elevation float64, temp float64) float64 {
+	if math.IsNaN(temp) {
+		return pressure * math.Pow(1-LAPSE_RATE*elevation/288.15, -5.25588)
+	}
+	mean := temp + 273.15 + LAPSE_RATE*elevation/2
+	return pressure * math.Exp(9.80665*elevation/(287.05*mean))
+}
+
+// SeaPressure gets the pressure at sea level in hPa from a set of readings.
+// The pressure at sea level that a station reports is used as is, otherwise the
+// station pressure is reduced if the elevation of the station is known. The
+// station pressure isn't used as is, since above sea level it is low enough to
+// always forecast storms.
+func SeaPressure(sensors map[string]float64, elevation *float64) (float64, bool) {
+	if pressure, exists := sensors["barom-sea"]; exists {
+		return pressure, true
+	}
+	pressure, exists := sensors["barom"]
+	if !exists || elevation == nil {
+		return 0, false
+	}
+	temp, exists := sensors["temp"]
+	if !exists {
+		temp = math.NaN()
+	}
+	return SeaLevelPressure(pressure, *elevation, temp), true
+}
+
+// FetchForecast reads the last few hours of a station to forecast the weather.
+// False is returned if there isn't enough recent pressure data.
+func FetchForecast(db *sql.DB, station string, latitude float64, elevation *float64, now time.Time) (Forecast, bool, error) {
+	conditions, err := database.FetchConditions(
+		db,
+		fmt.Sprintf(`WHERE %v AND time BETWEEN ? AND ? ORDER BY time`, database.STATION_FILTER),
+		station, now.Add(-TENDENCY_WINDOW), now,
+	)
+	if err != nil {
+		return Forecast{}, false, err
+	}
+
+	var first *database.Condition = nil
+	var last *database.Condition = nil
+	for i := range conditions {
+		if _, exists := SeaPressure(conditions[i].Sensors, elevation); !exists {
+			continue
+		}
+		if first == nil {
+			first = &conditions[i]
+		}
+		last = &conditions[i]
+	}
+	if first == nil || last.Time.Sub(first.Time) < TENDENCY_MIN_SPAN {
+		return Forecast{}, false, nil
+	}
+
+	start, _ := SeaPressure(first.Sensors, elevation)
+	pressure, _ := SeaPressure(last.Sensors, elevation)
+	span := last.Time.Sub(first.Time)
+	change := (pressure - start) * float64(TENDENCY_WINDOW) / float64(span)
+
+	wind := math.NaN()
+	for _, name := range []string{"winddir-avg10m", "winddir-avg2m", "winddir"} {
+		if dir, exists := last.Sensors[name]; exists {
+			wind = dir
+			break
+		}
+	}
+
+	letter, text := Zambretti(pressure, change, wind, last.Time.Local().Month(), latitude < 0)
+	return Forecast{
+		Time:     last.Time,
+		Pressure: pressure,
+		Change:   change,
+		Tendency: Tendency(change),
+		Letter:   letter,
+		Text:     text,
+	}, true, nil
+}
@@ -0,0 +1,164 @@
+package weather
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/ttocsneb/station-webapp/database"
+)
+
+func near(a float64, b float64, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func elevation(meters float64) *float64 {
+	return &meters
+}
+
+func TestSeaLevelPressure(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		pressure  float64
+		elevation float64
+		temp      float64
+		want      float64
+	}{
+		{"sea level", 1013.25, 0, 15, 1013.25},
+		{"standard atmosphere", 898.75, 1000, math.NaN(), 1013.25},
+		{"standard temperature", 898.75, 1000, 8.5, 1013.25},
+		{"warm mountain station", 858.05, 1400, 20, 1007.6},
+		{"cold mountain station", 858.05, 1400, -10, 1025.9},
+	} {
+		got := SeaLevelPressure(tc.pressure, tc.elevation, tc.temp)
+		if !near(got, tc.want, 0.1) {
+			t.Errorf("%v: expected %v hPa, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestSeaPressure(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		sensors   map[string]float64
+		elevation *float64
+		want      float64
+		exists    bool
+	}{
+		{"reported", map[string]float64{"barom": 858.05, "barom-sea": 1010}, elevation(1400), 1010, true},
+		{"reported without elevation", map[string]float64{"barom-sea": 1010}, nil, 1010, true},
+		{"reduced", map[string]float64{"barom": 858.05, "temp": 20}, elevation(1400), 1007.6, true},
+		{"reduced without temperature", map[string]float64{"barom": 898.75}, elevation(1000), 1013.25, true},
+		{"at sea level", map[string]float64{"barom": 1005}, elevation(0), 1005, true},
+		{"unknown elevation", map[string]float64{"barom": 858.05}, nil, 0, false},
+		{"no pressure", map[string]float64{"temp": 20}, elevation(1400), 0, false},
+	} {
+		got, exists := SeaPressure(tc.sensors, tc.elevation)
+		if exists != tc.exists || !near(got, tc.want, 0.1) {
+			t.Errorf("%v: expected %v (%v), got %v (%v)", tc.name, tc.want, tc.exists, got, exists)
+		}
+	}
+}
+
+func TestTendency(t *testing.T) {
+	for _, tc := range []struct {
+		change float64
+		want   string
+	}{
+		{0, "Steady"},
+		{-0.05, "Steady"},
+		{1, "Rising slowly"},
+		{-1, "Falling slowly"},
+		{2, "Rising"},
+		{-5, "Falling quickly"},
+		{7, "Rising very rapidly"},
+	} {
+		if got := Tendency(tc.change); got != tc.want {
+			t.Errorf("expected a change of %v to be %q, got %q", tc.change, tc.want, got)
+		}
+	}
+}
+
+func TestZambretti(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		pressure float64
+		change   float64
+		wind     float64
+		month    time.Month
+		southern bool
+		letter   string
+	}{
+		{"high and steady", 1030, 0, math.NaN(), time.July, false, "A"},
+		{"low and falling in winter", 990, -2, math.NaN(), time.January, false, "Z"},
+		{"rising in summer", 1000, 2, math.NaN(), time.July, false, "F"},
+		{"steady with a north wind", 1000, 0, 0, time.July, false, "K"},
+		{"steady with a south wind", 1000, 0, 180, time.July, false, "W"},
+		{"south wind in the southern hemisphere", 1000, 0, 180, time.January, true, "K"},
+		{"far below the range", 900, 0, math.NaN(), time.July, false, "Z"},
+		{"far above the range", 1100, 0, math.NaN(), time.July, false, "A"},
+	} {
+		letter, text := Zambretti(tc.pressure, tc.change, tc.wind, tc.month, tc.southern)
+		if letter != tc.letter {
+			t.Errorf("%v: expected %v, got %v (%v)", tc.name, tc.letter, letter, text)
+		}
+	}
+}
+
+func openDb(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", t.TempDir()+"/db.sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestFetchForecast(t *testing.T) {
+	db := openDb(t)
+	now := time.Date(2024, time.July, 1, 12, 0, 0, 0, time.UTC)
+	// The station pressure falls 3 hPa over 3 hours
+	for i := 0; i <= 18; i++ {
+		condition := database.NewCondition("roof", now.Add(-TENDENCY_WINDOW).Add(time.Duration(i)*10*time.Minute))
+		condition.Sensors["barom"] = 860 - float64(i)/6
+		condition.Sensors["temp"] = 20
+		condition.Sensors["winddir-avg10m"] = 270
+		if err := condition.InsertDb(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, exists, err := FetchForecast(db, "roof", 40, nil, now); err != nil || exists {
+		t.Errorf("expected no forecast without an elevation, got %v (%v)", exists, err)
+	}
+
+	forecast, exists, err := FetchForecast(db, "roof", 40, elevation(1400), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected a forecast")
+	}
+	if want := SeaLevelPressure(857, 1400, 20); !near(forecast.Pressure, want, 0.01) {
+		t.Errorf("expected a pressure of %v, got %v", want, forecast.Pressure)
+	}
+	if !near(forecast.Change, -3.5, 0.1) {
+		t.Errorf("expected the pressure at sea level to fall about 3.5 hPa, got %v", forecast.Change)
+	}
+	if forecast.Tendency != "Falling" {
+		t.Errorf("expected the pressure to be falling, got %v", forecast.Tendency)
+	}
+	if forecast.Letter == "" || forecast.Text == "" {
+		t.Errorf("expected a forecast, got %+v", forecast)
+	}
+
+	if _, exists, err := FetchForecast(db, "roof", 40, elevation(1400), now.Add(time.Hour*4)); err != nil || exists {
+		t.Errorf("expected no forecast without recent conditions, got %v (%v)", exists, err)
+	}
+}
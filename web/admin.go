@@ -0,0 +1,67 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/util"
+)
+
+// What a reload changed
+type ReloadReport struct {
+	// The settings that changed and have been applied
+	Applied []string `json:"applied"`
+	// The settings that changed, but keep their old value until a restart
+	Restart []string `json:"restart"`
+	// The settings that changed, but could not be applied and keep their old
+	// value
+	Failed []string `json:"failed"`
+}
+
+// A reload that was only partly applied
+type reloadError struct {
+	ReloadReport
+	Error string `json:"error"`
+}
+
+// Check the bearer token of an admin request. Without a token in the config,
+// nobody is an admin.
+func isAdmin(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	given, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func serveReload(reload func() (ReloadReport, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := util.Conf().Admin.Token
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !isAdmin(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(401)
+			return
+		}
+
+		report, err := reload()
+		if err != nil && len(report.Failed) == 0 {
+			// The config is invalid, so the previous config is still in use
+			writeJsonError(w, 400, err)
+			return
+		}
+		if err != nil {
+			// The config is valid, but not all of it could be applied. The
+			// settings that were applied stay in use.
+			logrus.Errorf("Could not reload %v: %v", strings.Join(report.Failed, ", "), err)
+			writeJson(w, 500, reloadError{ReloadReport: report, Error: err.Error()})
+			return
+		}
+		writeJson(w, 200, report)
+	}
+}
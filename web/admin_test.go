@@ -0,0 +1,59 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ttocsneb/station-webapp/util"
+)
+
+func TestServeReload(t *testing.T) {
+	previous := util.Conf()
+	t.Cleanup(func() { util.SetConf(previous) })
+
+	applied := ReloadReport{Applied: []string{"alerts"}, Restart: []string{"listen"}, Failed: []string{}}
+	partial := ReloadReport{Applied: []string{"alerts"}, Restart: []string{}, Failed: []string{"mqtt_server"}}
+	for _, tc := range []struct {
+		name   string
+		token  string
+		header string
+		report ReloadReport
+		err    error
+		status int
+	}{
+		{"without a token", "", "Bearer secret", applied, nil, 404},
+		{"unauthorized", "secret", "", applied, nil, 401},
+		{"wrong token", "secret", "Bearer guess", applied, nil, 401},
+		{"applied", "secret", "Bearer secret", applied, nil, 200},
+		{"invalid", "secret", "Bearer secret", ReloadReport{}, errors.New("invalid qos"), 400},
+		{"partly applied", "secret", "Bearer secret", partial, errors.New("connection refused"), 500},
+	} {
+		util.SetConf(&util.Config{Admin: util.AdminConfig{Token: tc.token}})
+		handler := serveReload(func() (ReloadReport, error) { return tc.report, tc.err })
+
+		r := httptest.NewRequest("POST", "/admin/reload", nil)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%v: expected %v, got %v", tc.name, tc.status, w.Code)
+			continue
+		}
+		if tc.status < 400 || tc.status == 500 {
+			var body reloadError
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%v: %v", tc.name, err)
+			}
+			if len(body.Applied) != 1 || body.Applied[0] != "alerts" || len(body.Failed) != len(tc.report.Failed) {
+				t.Errorf("%v: unexpected report %+v", tc.name, body)
+			}
+			if tc.err != nil && body.Error != tc.err.Error() {
+				t.Errorf("%v: expected the error %q, got %q", tc.name, tc.err, body.Error)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/station"
+)
+
+type almanacPeriod struct {
+	Name  string
+	Label string
+	Start string
+	// How the time of a record is shown
+	TimeFormat string
+}
+
+type almanacRow struct {
+	Sensor chartSensor
+	// The records of each period by their kind
+	Records map[string]map[string]*database.Record
+}
+
+func almanacPeriods(date time.Time) []almanacPeriod {
+	return []almanacPeriod{
+		{
+			Name:       database.PERIOD_DAY,
+			Label:      date.Format("Jan 2, 2006"),
+			Start:      database.PeriodStart(database.PERIOD_DAY, date),
+			TimeFormat: "3:04 PM",
+		},
+		{
+			Name:       database.PERIOD_MONTH,
+			Label:      date.Format("January 2006"),
+			Start:      database.PeriodStart(database.PERIOD_MONTH, date),
+			TimeFormat: "Jan 2 3:04 PM",
+		},
+		{
+			Name:       database.PERIOD_YEAR,
+			Label:      date.Format("2006"),
+			Start:      database.PeriodStart(database.PERIOD_YEAR, date),
+			TimeFormat: "Jan 2 3:04 PM",
+		},
+		{
+			Name:       database.PERIOD_ALL,
+			Label:      "All Time",
+			Start:      database.PeriodStart(database.PERIOD_ALL, date),
+			TimeFormat: "Jan 2, 2006",
+		},
+	}
+}
+
+// Get the day that the almanac is for, which is today by default
+func parseAlmanacDate(r *http.Request) (time.Time, error) {
+	value := r.Form.Get("date")
+	if value == "" {
+		return time.Now(), nil
+	}
+	date, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid date: %w", err)
+	}
+	return date, nil
+}
+
+func fetchAlmanac(db *sql.DB, station_id string, periods []almanacPeriod) ([]almanacRow, error) {
+	rows := make(map[string]*almanacRow)
+	for _, period := range periods {
+		records, err := database.FetchRecords(db, station_id, period.Name, period.Start)
+		if err != nil {
+			return nil, err
+		}
+		for i := range records {
+			record := &records[i]
+			record.Time = record.Time.Local()
+			row, exists := rows[record.Sensor]
+			if !exists {
+				row = &almanacRow{
+					Sensor:  getChartSensor(record.Sensor),
+					Records: make(map[string]map[string]*database.Record),
+				}
+				rows[record.Sensor] = row
+			}
+			if _, exists := row.Records[period.Name]; !exists {
+				row.Records[period.Name] = make(map[string]*database.Record)
+			}
+			row.Records[period.Name][record.Kind] = record
+		}
+	}
+
+	names := make([]string, 0, len(rows))
+	for name := range rows {
+		names = append(names, name)
+	}
+	ordered := []almanacRow{}
+	for _, sensor := range orderSensors(names) {
+		ordered = append(ordered, *rows[sensor.Name])
+	}
+	return ordered, nil
+}
+
+func serveAlmanac(db *sql.DB, client *station.Station, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Invalid Request", 400)
+			return
+		}
+
+		cookie, err := r.Cookie("system")
+		system := METRIC
+		if err == nil {
+			system = cookie.Value
+		}
+
+		date, err := parseAlmanacDate(r)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		periods := almanacPeriods(date)
+		rows, err := fetchAlmanac(db, client.Id(), periods)
+		if err != nil {
+			logError(w, err)
+			return
+		}
+
+		err = renderTemplate(w, "almanac.html", vars{
+			"Title":    "Almanac",
+			"System":   system,
+			"Page":     r.URL.RequestURI(),
+			"Nav":      "almanac",
+			"Station":  client,
+			"Stations": station.All(),
+			"Prefix":   prefix,
+			"Date":     date.Format(time.DateOnly),
+			"Periods":  periods,
+			"Rows":     rows,
+			"Kinds":    []string{"min", "max"},
+		})
+
+		if err != nil {
+			logError(w, err)
+			w.Write([]byte("<p>Invalid template</p>"))
+			return
+		}
+	}
+}
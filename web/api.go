@@ -0,0 +1,514 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/station"
+	"github.com/ttocsneb/station-webapp/units"
+	"github.com/ttocsneb/station-webapp/util"
+)
+
+const apiDefaultLimit = 1000
+const apiMaxLimit = 10000
+
+type apiSensorValue struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+type apiCondition struct {
+	Id      int                       `json:"id"`
+	Href    string                    `json:"href"`
+	Station string                    `json:"station"`
+	Time    time.Time                 `json:"time"`
+	Sensors map[string]apiSensorValue `json:"sensors"`
+}
+
+type apiSensor struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	Unit string `json:"unit"`
+}
+
+type apiStation struct {
+	Id       string        `json:"id"`
+	Name     string        `json:"name"`
+	Location string        `json:"location"`
+	Href     string        `json:"href"`
+	Latest   *apiCondition `json:"latest"`
+}
+
+type apiRecord struct {
+	Value float64   `json:"value"`
+	Unit  string    `json:"unit"`
+	Time  time.Time `json:"time"`
+}
+
+type apiPeriod struct {
+	Period  string                          `json:"period"`
+	Start   string                          `json:"start"`
+	Records map[string]map[string]apiRecord `json:"records"`
+}
+
+type apiStationStatus struct {
+	Id          string     `json:"id"`
+	Online      bool       `json:"online"`
+	LastMessage *time.Time `json:"last_message"`
+	StaleAfter  string     `json:"stale_after"`
+}
+
+type apiHealth struct {
+	Status    string             `json:"status"`
+	Connected bool               `json:"connected"`
+	Stations  []apiStationStatus `json:"stations"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJson(w http.ResponseWriter, status int, data any) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		logError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeJsonError(w http.ResponseWriter, status int, err error) {
+	if status >= 500 {
+		logrus.Error(err)
+	}
+	writeJson(w, status, apiError{Error: err.Error()})
+}
+
+// Get the unit system requested by the api, an empty system leaves every
+// sensor in its canonical unit
+func apiSystem(r *http.Request) (string, error) {
+	system := r.Form.Get("system")
+	if system != "" && system != METRIC && system != IMPERIAL && system != MIXED {
+		return "", fmt.Errorf("Unknown system %v", system)
+	}
+	return system, nil
+}
+
+// Get the station requested by the api, which is the first station by default
+func apiStationId(r *http.Request) (string, error) {
+	id := r.Form.Get("station")
+	if id == "" {
+		stations := station.All()
+		if len(stations) == 0 {
+			return "", errors.New("No stations are configured")
+		}
+		return stations[0].Id(), nil
+	}
+	return id, nil
+}
+
+func newApiCondition(condition database.Condition, sensors []string, system string) apiCondition {
+	result := apiCondition{
+		Id:      condition.Id,
+		Href:    route(fmt.Sprintf("/api/v1/conditions/%d", condition.Id)),
+		Station: condition.Station,
+		Time:    condition.Time,
+		Sensors: make(map[string]apiSensorValue),
+	}
+
+	for name, value := range condition.Sensors {
+		if len(sensors) != 0 && !sensorSelected(sensors, name) {
+			continue
+		}
+		unit := database.GetUnit(name)
+		if system != "" {
+			// Clients round for themselves
+			value, unit = units.ToSystem(value, unit, units.Kind(unit), system)
+		}
+		result.Sensors[name] = apiSensorValue{
+			Value: value,
+			Unit:  unit,
+		}
+	}
+
+	return result
+}
+
+func sensorSelected(sensors []string, name string) bool {
+	for _, sensor := range sensors {
+		if sensor == name {
+			return true
+		}
+	}
+	return false
+}
+
+func serveApiCurrent(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+		system, err := apiSystem(r)
+		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+
+		station_id, err := apiStationId(r)
+		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+
+		condition, err := database.FetchLatestCondition(db, station_id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJsonError(w, 404, errors.New("No conditions have been recorded"))
+			return
+		}
+		if err != nil {
+			writeJsonError(w, 500, err)
+			return
+		}
+
+		writeJson(w, 200, newApiCondition(condition, nil, system))
+	}
+}
+
+func serveApiCondition(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+		system, err := apiSystem(r)
+		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+
+		condition, err := database.FetchCondition(db, "WHERE id = ?", id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJsonError(w, 404, fmt.Errorf("Condition %d does not exist", id))
+			return
+		}
+		if err != nil {
+			writeJsonError(w, 500, err)
+			return
+		}
+
+		writeJson(w, 200, newApiCondition(condition, nil, system))
+	}
+}
+
+func serveApiConditions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+		system, err := apiSystem(r)
+		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+
+		to := time.Now()
+		if value := r.Form.Get("to"); value != "" {
+			to, err = util.ParseTime(value)
+			if err != nil {
+				writeJsonError(w, 400, fmt.Errorf("Invalid to: %w", err))
+				return
+			}
+		}
+		from := to.Add(-time.Hour * 24)
+		if value := r.Form.Get("from"); value != "" {
+			from, err = util.ParseTime(value)
+			if err != nil {
+				writeJsonError(w, 400, fmt.Errorf("Invalid from: %w", err))
+				return
+			}
+		}
+
+		limit := apiDefaultLimit
+		if value := r.Form.Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				writeJsonError(w, 400, fmt.Errorf("Invalid limit %v", value))
+				return
+			}
+			limit = min(limit, apiMaxLimit)
+		}
+
+		station_id, err := apiStationId(r)
+		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+
+		sensors := []string{}
+		if value := r.Form.Get("sensors"); value != "" {
+			for _, name := range strings.Split(value, ",") {
+				sensors = append(sensors, strings.TrimSpace(name))
+			}
+		}
+
+		conditions, err := database.FetchConditions(
+			db,
+			fmt.Sprintf(`WHERE %v AND time BETWEEN ? AND ? ORDER BY time LIMIT ?`, database.STATION_FILTER),
+			station_id, from, to, limit,
+		)
+		if err != nil {
+			writeJsonError(w, 500, err)
+			return
+		}
+
+		result := make([]apiCondition, len(conditions))
+		for i, condition := range conditions {
+			result[i] = newApiCondition(condition, sensors, system)
+		}
+
+		writeJson(w, 200, map[string]any{
+			"station":    station_id,
+			"from":       from,
+			"to":         to,
+			"limit":      limit,
+			"conditions": result,
+		})
+	}
+}
+
+func serveApiStations(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+		system, err := apiSystem(r)
+		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+
+		clients := station.All()
+		stations := make([]apiStation, len(clients))
+		for i, client := range clients {
+			stations[i] = apiStation{
+				Id:       client.Id(),
+				Name:     client.Name(),
+				Location: client.Location(),
+				Href:     route(stationPrefix(client) + "/"),
+			}
+			condition, err := database.FetchLatestCondition(db, client.Id())
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				writeJsonError(w, 500, err)
+				return
+			}
+			latest := newApiCondition(condition, nil, system)
+			stations[i].Latest = &latest
+		}
+
+		writeJson(w, 200, stations)
+	}
+}
+
+// The health is ok when every station is online, and responds with 503 otherwise
+func serveApiHealth(w http.ResponseWriter, r *http.Request) {
+	clients := station.All()
+	health := apiHealth{
+		Status:    "ok",
+		Connected: station.IsConnected(),
+		Stations:  make([]apiStationStatus, len(clients)),
+	}
+	for i, client := range clients {
+		status := client.Status()
+		health.Stations[i] = apiStationStatus{
+			Id:         client.Id(),
+			Online:     status.Online,
+			StaleAfter: client.StaleAfter().String(),
+		}
+		if !status.LastMessage.IsZero() {
+			health.Stations[i].LastMessage = &status.LastMessage
+		}
+		if !status.Online {
+			health.Status = "offline"
+		}
+	}
+
+	code := 200
+	if health.Status != "ok" {
+		code = 503
+	}
+	writeJson(w, code, health)
+}
+
+func serveApiSensors(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lookup, err := database.FetchAllLookupStrings(db)
+		if err != nil {
+			writeJsonError(w, 500, err)
+			return
+		}
+
+		sensors := []apiSensor{}
+		for name, id := range lookup {
+			sensors = append(sensors, apiSensor{
+				Id:   id,
+				Name: name,
+				Unit: database.GetUnit(name),
+			})
+		}
+		sort.Slice(sensors, func(i, j int) bool {
+			return sensors[i].Name < sensors[j].Name
+		})
+
+		writeJson(w, 200, sensors)
+	}
+}
+
+func serveApiAlmanac(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+		system, err := apiSystem(r)
+		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+		station_id, err := apiStationId(r)
+		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+		date, err := parseAlmanacDate(r)
+		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+
+		periods := []apiPeriod{}
+		for _, period := range almanacPeriods(date) {
+			records, err := database.FetchRecords(db, station_id, period.Name, period.Start)
+			if err != nil {
+				writeJsonError(w, 500, err)
+				return
+			}
+
+			result := apiPeriod{
+				Period:  period.Name,
+				Start:   period.Start,
+				Records: make(map[string]map[string]apiRecord),
+			}
+			for _, record := range records {
+				value := record.Value
+				unit := database.GetUnit(record.Sensor)
+				if system != "" {
+					value, unit = units.ToSystem(value, unit, units.Kind(unit), system)
+				}
+				if _, exists := result.Records[record.Sensor]; !exists {
+					result.Records[record.Sensor] = make(map[string]apiRecord)
+				}
+				result.Records[record.Sensor][record.Kind] = apiRecord{
+					Value: value,
+					Unit:  unit,
+					Time:  record.Time,
+				}
+			}
+			periods = append(periods, result)
+		}
+
+		writeJson(w, 200, map[string]any{
+			"station": station_id,
+			"periods": periods,
+		})
+	}
+}
+
+func serveApiExport(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+
+		var err error
+		from := time.Time{}
+		if value := r.Form.Get("from"); value != "" {
+			from, err = util.ParseTime(value)
+			if err != nil {
+				writeJsonError(w, 400, fmt.Errorf("Invalid from: %w", err))
+				return
+			}
+		}
+		to := time.Now()
+		if value := r.Form.Get("to"); value != "" {
+			to, err = util.ParseTime(value)
+			if err != nil {
+				writeJsonError(w, 400, fmt.Errorf("Invalid to: %w", err))
+				return
+			}
+		}
+
+		format := r.Form.Get("format")
+		var content_type string
+		switch format {
+		case "", database.FORMAT_CSV:
+			format = database.FORMAT_CSV
+			content_type = "text/csv"
+		case database.FORMAT_NDJSON:
+			content_type = "application/x-ndjson"
+		default:
+			writeJsonError(w, 400, fmt.Errorf("Unknown format %v", format))
+			return
+		}
+
+		w.Header().Set("Content-Type", content_type)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(
+			"attachment; filename=\"conditions.%v\"", format,
+		))
+		w.WriteHeader(200)
+
+		// Every station is exported unless one is requested. The headers have
+		// already been sent, so the most that can be done for an error is to
+		// log it and cut the download short.
+		err = database.Export(db, w, format, r.Form.Get("station"), from, to)
+		if err != nil {
+			logrus.Errorf("Could not export conditions: %v", err)
+		}
+	}
+}
+
+func registerApi(router *mux.Router, db *sql.DB) {
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/current", serveApiCurrent(db)).Methods("GET")
+	api.HandleFunc("/conditions", serveApiConditions(db)).Methods("GET")
+	api.HandleFunc("/conditions/{id:[0-9]+}", serveApiCondition(db)).Methods("GET")
+	api.HandleFunc("/sensors", serveApiSensors(db)).Methods("GET")
+	api.HandleFunc("/stations", serveApiStations(db)).Methods("GET")
+	api.HandleFunc("/almanac", serveApiAlmanac(db)).Methods("GET")
+	api.HandleFunc("/export", serveApiExport(db)).Methods("GET")
+	api.HandleFunc("/health", serveApiHealth).Methods("GET")
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gabriel-vasile/mimetype"
@@ -52,6 +53,7 @@ type cachedFile struct {
 }
 
 var static_cache = map[staticKey]cachedFile{}
+var static_lock sync.RWMutex
 
 func serveStatic(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
@@ -85,7 +87,10 @@ func serveStatic(w http.ResponseWriter, r *http.Request) {
 		Name: r.URL.Path[1:],
 		Gzip: is_gzip,
 	}
-	if cache, exists := static_cache[cache_key]; exists {
+	static_lock.RLock()
+	cache, exists := static_cache[cache_key]
+	static_lock.RUnlock()
+	if exists {
 		w.Header().Set("Last-Modified", cache.modTime.Format(time.RFC1123))
 		w.Header().Set("Content-Type", cache.contentType)
 
@@ -111,7 +116,7 @@ func serveStatic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cache := cachedFile{}
+	cache = cachedFile{}
 
 	cache.modTime = lastModTime()
 
@@ -192,7 +197,9 @@ func serveStatic(w http.ResponseWriter, r *http.Request) {
 		new_data[i] = c
 	}
 	cache.Data = new_data
+	static_lock.Lock()
 	static_cache[cache_key] = cache
+	static_lock.Unlock()
 
 	w.Write(data)
 }
@@ -8,6 +8,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/units"
 	"github.com/ttocsneb/station-webapp/util"
 )
 
@@ -171,119 +173,12 @@ func ftime(t time.Time, format string) string {
 	return t.Format(format)
 }
 
-const IMPERIAL = "imperial"
-const METRIC = "metric"
-const MIXED = "mixed"
-
-func convertTemp(value float64, unit string, system string) (float64, string) {
-	if unit == "K" {
-		value = value - 272.15
-		unit = "C"
-	}
-	if unit == "C" && system == IMPERIAL {
-		value = value*9/5 + 32
-		unit = "F"
-	}
-	if unit == "F" && (system == METRIC || system == MIXED) {
-		value = (value - 32) * 5 / 9
-		unit = "C"
-	}
-
-	return float64(round(value)), unit
-}
-
-// barom: 858.05
-// dailyrain: 0
-// dewpoint: -6.344679
-// humidity: 44.08316
-// rain-1h: 0
-// temp: 4.8760285
-// uv: 0
-// winddir: 270
-// winddir-avg10m: 99
-// winddir-avg2m: 277
-// windgustdir-2m: 270
-// windgustspd-2m: 1.0054344
-// windspd: 1.0054344
-// windspd-avg10m: 0.77418447
-// windspd-avg2m: 0.3267662
-
-func convertPressure(value float64, unit string, system string) (float64, string) {
-	if unit == "Pa" {
-		value = value / 100
-		unit = "hPa"
-	}
-	if unit == "kPa" {
-		value = value * 10
-		unit = "hPa"
-	}
-	if unit == "hPa" && (system == IMPERIAL || system == MIXED) {
-		value = value / 33.86388666666671
-		unit = "inHg"
-	}
-	if unit == "inHg" && system == METRIC {
-		value = value * 33.86388666666671
-		unit = "hPa"
-	}
-
-	if unit == "hPa" {
-		value = round_nth(value, 1)
-	} else if unit == "inHg" {
-		value = round_nth(value, 2)
-	}
-
-	return value, unit
-}
-
-func convertRain(value float64, unit string, system string) (float64, string) {
-	if unit == "in" && system == METRIC {
-		value = value * 25.4
-		unit = "mm"
-	}
-	if unit == "mm" && (system == IMPERIAL || system == MIXED) {
-		value = value / 25.4
-		unit = "in"
-	}
-
-	if unit == "mm" {
-		value = round_nth(value, 1)
-	} else if unit == "in" {
-		value = round_nth(value, 2)
-	}
-
-	return value, unit
-}
-
-func convertSpeed(value float64, unit string, system string) (float64, string) {
-	if unit == "m/s" {
-		unit = "km/h"
-		value = value * 3.6
-	}
-	if unit == "km/h" && (system == IMPERIAL || system == MIXED) {
-		value = value / 1.609344
-		unit = "mph"
-	}
-	if unit == "mph" && (system == METRIC) {
-		value = value * 1.609344
-		unit = "km/h"
-	}
-
-	return float64(round(value)), unit
-}
+const IMPERIAL = units.IMPERIAL
+const METRIC = units.METRIC
+const MIXED = units.MIXED
 
 func convert(value float64, unit string, sensor string, system string) (float64, string) {
-	switch sensor {
-	case "temp":
-		return convertTemp(value, unit, system)
-	case "rain":
-		return convertRain(value, unit, system)
-	case "pressure":
-		return convertPressure(value, unit, system)
-	case "speed":
-		return convertSpeed(value, unit, system)
-	}
-
-	return value, unit
+	return units.ConvertSystem(value, unit, sensor, system)
 }
 
 func get_unit(value float64, unit string, sensor string, system string) string {
@@ -295,8 +190,14 @@ func get_value(value float64, unit string, sensor string, system string) float64
 	return value
 }
 
+// Check whether a condition has a reading for a sensor
+func has_sensor(sensors map[string]float64, name string) bool {
+	_, exists := sensors[name]
+	return exists
+}
+
 func route(path string) string {
-	return fmt.Sprintf("%v%v", util.Conf.Base, path)
+	return fmt.Sprintf("%v%v", util.Conf().Base, path)
 }
 
 var funcs = template.FuncMap{
@@ -311,4 +212,6 @@ var funcs = template.FuncMap{
 	"convert":             get_value,
 	"get_unit":            get_unit,
 	"route":               route,
+	"unit":                database.GetUnit,
+	"has":                 has_sensor,
 }
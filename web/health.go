@@ -0,0 +1,97 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/station"
+	"github.com/ttocsneb/station-webapp/util"
+)
+
+type readyCheck struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type readyResponse struct {
+	Status string       `json:"status"`
+	Checks []readyCheck `json:"checks,omitempty"`
+}
+
+// Time a check, which fails if it returns an error
+func runCheck(name string, check func() error) readyCheck {
+	start := time.Now()
+	err := check()
+	result := readyCheck{
+		Name:    name,
+		Status:  "ok",
+		Latency: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// The process is alive as long as it can answer
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, 200, readyResponse{Status: "ok"})
+}
+
+// The app is ready when the database answers and is migrated, the mqtt server
+// is connected, and every station has sent conditions recently. Responds with
+// 503 when any check fails.
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := []readyCheck{
+		runCheck("database", func() error {
+			if database.DB == nil {
+				return fmt.Errorf("The database is not open")
+			}
+			var one int
+			return database.DB.QueryRowContext(r.Context(), `SELECT 1;`).Scan(&one)
+		}),
+		runCheck("migrations", func() error {
+			if database.DB == nil {
+				return fmt.Errorf("The database is not open")
+			}
+			return database.CheckVersion(database.DB)
+		}),
+		runCheck("mqtt", func() error {
+			if stations := station.All(); len(stations) == 0 || !stations[0].Client().IsConnectionOpen() {
+				return fmt.Errorf("The mqtt server is not connected")
+			}
+			return nil
+		}),
+	}
+	for _, client := range station.All() {
+		checks = append(checks, runCheck("station:"+client.Id(), func() error {
+			max_age := util.Conf().Health.MaxAge.Duration
+			if max_age <= 0 {
+				max_age = client.StaleAfter()
+			}
+			last := client.Status().LastMessage
+			if last.IsZero() {
+				return fmt.Errorf("No conditions have been received")
+			}
+			if age := time.Since(last); age > max_age {
+				return fmt.Errorf("The last conditions are %v old", age.Round(time.Second))
+			}
+			return nil
+		}))
+	}
+
+	response := readyResponse{Status: "ok", Checks: checks}
+	code := 200
+	for _, check := range checks {
+		if check.Status != "ok" {
+			response.Status = "fail"
+			code = 503
+		}
+	}
+	writeJson(w, code, response)
+}
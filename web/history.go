@@ -0,0 +1,385 @@
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/station"
+	"github.com/ttocsneb/station-webapp/units"
+)
+
+type chartSensor struct {
+	Name  string
+	Label string
+	Unit  string
+	Kind  string
+}
+
+var chartLabels = []struct {
+	Name  string
+	Label string
+}{
+	{Name: "temp", Label: "Temperature"},
+	{Name: "dewpoint", Label: "Dew Point"},
+	{Name: "humidity", Label: "Humidity"},
+	{Name: "barom", Label: "Pressure"},
+	{Name: "barom-sea", Label: "Pressure at Sea Level"},
+	{Name: "rain-1h", Label: "Rain (Hour)"},
+	{Name: "dailyrain", Label: "Rain (Day)"},
+	{Name: "windspd", Label: "Wind Speed"},
+	{Name: "windspd-avg2m", Label: "Wind Speed (2m)"},
+	{Name: "windspd-avg10m", Label: "Wind Speed (10m)"},
+	{Name: "windgustspd-2m", Label: "Gust Speed"},
+	{Name: "winddir", Label: "Wind Direction"},
+	{Name: "winddir-avg2m", Label: "Wind Direction (2m)"},
+	{Name: "winddir-avg10m", Label: "Wind Direction (10m)"},
+	{Name: "windgustdir-2m", Label: "Gust Direction"},
+	{Name: "uv", Label: "UV"},
+	{Name: "apparent-temp", Label: "Feels Like"},
+	{Name: "heatindex", Label: "Heat Index"},
+	{Name: "windchill", Label: "Wind Chill"},
+	{Name: "humidex", Label: "Humidex"},
+	{Name: "cloudbase", Label: "Cloud Base"},
+}
+
+func getChartSensor(name string) chartSensor {
+	unit := database.GetUnit(name)
+	sensor := chartSensor{
+		Name:  name,
+		Label: name,
+		Unit:  unit,
+		Kind:  units.Kind(unit),
+	}
+	for _, label := range chartLabels {
+		if label.Name == name {
+			sensor.Label = label.Label
+		}
+	}
+	return sensor
+}
+
+// Order sensors the same as chartLabels, followed by every other sensor
+// alphabetically
+func orderSensors(names []string) []chartSensor {
+	remaining := make(map[string]bool)
+	for _, name := range names {
+		remaining[name] = true
+	}
+
+	sensors := []chartSensor{}
+	for _, label := range chartLabels {
+		if remaining[label.Name] {
+			sensors = append(sensors, getChartSensor(label.Name))
+			delete(remaining, label.Name)
+		}
+	}
+	others := []string{}
+	for name := range remaining {
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		sensors = append(sensors, getChartSensor(name))
+	}
+	return sensors
+}
+
+type historyRange struct {
+	Name     string
+	Label    string
+	Duration time.Duration
+}
+
+var historyRanges = []historyRange{
+	{Name: "24h", Label: "Last 24 Hours", Duration: time.Hour * 24},
+	{Name: "7d", Label: "Last 7 Days", Duration: time.Hour * 24 * 7},
+	{Name: "30d", Label: "Last 30 Days", Duration: time.Hour * 24 * 30},
+	{Name: "1y", Label: "Last Year", Duration: time.Hour * 24 * 365},
+	{Name: "custom", Label: "Custom", Duration: 0},
+}
+
+const chartWidth = 600.0
+const chartHeight = 200.0
+const chartLeft = 50.0
+const chartRight = 10.0
+const chartTop = 10.0
+const chartBottom = 20.0
+
+type chartTick struct {
+	Pos   float64
+	Label string
+}
+
+type chart struct {
+	Sensor chartSensor
+	Unit   string
+	Width  float64
+	Height float64
+	Left   float64
+	Right  float64
+	Top    float64
+	Bottom float64
+	Lines  []string
+	XTicks []chartTick
+	YTicks []chartTick
+	Min    float64
+	Max    float64
+	Empty  bool
+}
+
+// niceStep finds a step of 1, 2, or 5 times a power of 10 that splits span
+// into roughly count pieces
+func niceStep(span float64, count int) float64 {
+	if span <= 0 {
+		return 1
+	}
+	raw := span / float64(count)
+	magnitude := math.Pow10(int(math.Floor(math.Log10(raw))))
+	for _, mult := range []float64{1, 2, 5, 10} {
+		if raw <= mult*magnitude {
+			return mult * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func timeTickFormat(span time.Duration) string {
+	if span <= time.Hour*48 {
+		return "3:04 PM"
+	}
+	if span <= time.Hour*24*60 {
+		return "Jan 2"
+	}
+	return "Jan 2006"
+}
+
+func buildChart(
+	conditions []database.Condition,
+	sensor chartSensor,
+	begin time.Time,
+	end time.Time,
+	system string) chart {
+
+	// The values are plotted as they are, since rounding them for display
+	// would make every line step between whole units
+	_, unit := units.ToSystem(0, sensor.Unit, sensor.Kind, system)
+	result := chart{
+		Sensor: sensor,
+		Unit:   unit,
+		Width:  chartWidth,
+		Height: chartHeight,
+		Left:   chartLeft,
+		Right:  chartWidth - chartRight,
+		Top:    chartTop,
+		Bottom: chartHeight - chartBottom,
+	}
+
+	pairs := []database.Pair{}
+	for _, condition := range conditions {
+		val, exists := condition.Sensors[sensor.Name]
+		if !exists {
+			continue
+		}
+		value, _ := units.ToSystem(val, sensor.Unit, sensor.Kind, system)
+		pairs = append(pairs, database.Pair{
+			Time:  condition.Time,
+			Value: value,
+		})
+	}
+
+	span := end.Sub(begin)
+	format := timeTickFormat(span)
+	for i := 0; i <= 4; i++ {
+		t := begin.Add(span * time.Duration(i) / 4)
+		result.XTicks = append(result.XTicks, chartTick{
+			Pos:   result.Left + (result.Right-result.Left)*float64(i)/4,
+			Label: t.Local().Format(format),
+		})
+	}
+
+	if len(pairs) == 0 {
+		result.Empty = true
+		return result
+	}
+
+	min_value := pairs[0].Value
+	max_value := pairs[0].Value
+	for _, pair := range pairs {
+		min_value = math.Min(min_value, pair.Value)
+		max_value = math.Max(max_value, pair.Value)
+	}
+
+	step := niceStep(max_value-min_value, 4)
+	low := math.Floor(min_value/step) * step
+	high := math.Ceil(max_value/step) * step
+	if high == low {
+		high = low + step
+	}
+	result.Min = low
+	result.Max = high
+
+	y := func(value float64) float64 {
+		return result.Bottom - (value-low)/(high-low)*(result.Bottom-result.Top)
+	}
+	x := func(t time.Time) float64 {
+		return result.Left + t.Sub(begin).Seconds()/span.Seconds()*(result.Right-result.Left)
+	}
+
+	for tick := low; tick <= high+step/2; tick += step {
+		result.YTicks = append(result.YTicks, chartTick{
+			Pos:   round_nth(y(tick), 1),
+			Label: fmt.Sprint(round_nth(tick, 2)),
+		})
+	}
+
+	// Break the line wherever there is a gap in the data so that missing
+	// readings aren't drawn as a straight line
+	max_gap := max(span/48, time.Hour*2)
+
+	points := []string{}
+	for i, pair := range pairs {
+		if i > 0 && pair.Time.Sub(pairs[i-1].Time) > max_gap && len(points) > 0 {
+			result.Lines = append(result.Lines, strings.Join(points, " "))
+			points = []string{}
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x(pair.Time), y(pair.Value)))
+	}
+	result.Lines = append(result.Lines, strings.Join(points, " "))
+
+	return result
+}
+
+func parseHistoryRange(r *http.Request) (string, time.Time, time.Time, error) {
+	name := r.Form.Get("range")
+	if name == "" {
+		name = "24h"
+	}
+	now := time.Now()
+
+	if name == "custom" {
+		from, err := time.ParseInLocation(time.DateOnly, r.Form.Get("from"), time.Local)
+		if err != nil {
+			return name, time.Time{}, time.Time{}, fmt.Errorf("Invalid from date: %w", err)
+		}
+		to := now
+		if r.Form.Get("to") != "" {
+			to, err = time.ParseInLocation(time.DateOnly, r.Form.Get("to"), time.Local)
+			if err != nil {
+				return name, time.Time{}, time.Time{}, fmt.Errorf("Invalid to date: %w", err)
+			}
+			// The end date is inclusive
+			to = to.Add(time.Hour * 24)
+		}
+		if !from.Before(to) {
+			return name, time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+		}
+		return name, from, to, nil
+	}
+
+	for _, rng := range historyRanges {
+		if rng.Name == name {
+			return name, now.Add(-rng.Duration), now, nil
+		}
+	}
+
+	return name, time.Time{}, time.Time{}, fmt.Errorf("Unknown range %v", name)
+}
+
+func parseHistorySensors(r *http.Request) []string {
+	sensors := []string{}
+	for _, value := range r.Form["sensor"] {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				sensors = append(sensors, name)
+			}
+		}
+	}
+	if len(sensors) == 0 {
+		sensors = append(sensors, "temp")
+	}
+	return sensors
+}
+
+func serveHistory(db *sql.DB, client *station.Station, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Invalid Request", 400)
+			return
+		}
+
+		cookie, err := r.Cookie("system")
+		system := METRIC
+		if err == nil {
+			system = cookie.Value
+		}
+
+		range_name, begin, end, err := parseHistoryRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		selected := parseHistorySensors(r)
+
+		conditions := []database.Condition{}
+		err = database.StreamConditions(db, client.Id(), begin, end, func(condition database.Condition) error {
+			conditions = append(conditions, condition)
+			return nil
+		})
+		if err != nil {
+			logError(w, err)
+			return
+		}
+
+		charts := make([]chart, len(selected))
+		for i, name := range selected {
+			charts[i] = buildChart(conditions, getChartSensor(name), begin, end, system)
+		}
+
+		lookup, err := database.FetchAllLookupStrings(db)
+		if err != nil {
+			logError(w, err)
+			return
+		}
+		names := make([]string, 0, len(lookup))
+		for name := range lookup {
+			names = append(names, name)
+		}
+		available := orderSensors(names)
+
+		is_selected := make(map[string]bool)
+		for _, name := range selected {
+			is_selected[name] = true
+		}
+
+		err = renderTemplate(w, "history.html", vars{
+			"Title":    "History",
+			"System":   system,
+			"Page":     r.URL.RequestURI(),
+			"Nav":      "history",
+			"Station":  client,
+			"Stations": station.All(),
+			"Prefix":   prefix,
+			"Charts":   charts,
+			"Sensors":  available,
+			"Selected": is_selected,
+			"Ranges":   historyRanges,
+			"Range":    range_name,
+			"From":     begin.Local().Format(time.DateOnly),
+			"To":       end.Add(-time.Nanosecond).Local().Format(time.DateOnly),
+		})
+
+		if err != nil {
+			logError(w, err)
+			w.Write([]byte("<p>Invalid template</p>"))
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/station"
+)
+
+func receiveUpload(w http.ResponseWriter, client *station.Station, receive func() error) bool {
+	err := receive()
+	if errors.Is(err, station.ErrInvalidUpload) || errors.Is(err, database.ErrEmptyCondition) {
+		logrus.Warnf("Rejected upload from %v: %v", client.Id(), err)
+		http.Error(w, err.Error(), 400)
+		return false
+	}
+	if errors.Is(err, station.ErrClosed) {
+		http.Error(w, "Shutting down", 503)
+		return false
+	}
+	if err != nil {
+		logError(w, err)
+		return false
+	}
+	return true
+}
+
+// Receive conditions with the Weather Underground protocol
+// (/weatherstation/updateweatherstation.php?ID=station&PASSWORD=key&...)
+func serveWunderground(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Invalid Request", 400)
+		return
+	}
+
+	client := station.Find(r.Form.Get("ID"))
+	if client == nil || !client.CheckKey(r.Form.Get("PASSWORD")) {
+		http.Error(w, "unauthorized", 401)
+		return
+	}
+
+	if receiveUpload(w, client, func() error {
+		return client.ReceiveWunderground(r.Form)
+	}) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("success\n"))
+	}
+}
+
+// Receive conditions posted with the Ecowitt custom server protocol, where the
+// station is found by its PASSKEY
+func serveEcowitt(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Invalid Request", 400)
+		return
+	}
+
+	client := station.FindByKey(r.PostForm.Get("PASSKEY"))
+	if client == nil {
+		http.Error(w, "unauthorized", 401)
+		return
+	}
+
+	if receiveUpload(w, client, func() error {
+		return client.ReceiveEcowitt(r.PostForm)
+	}) {
+		w.WriteHeader(200)
+	}
+}
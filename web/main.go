@@ -11,15 +11,32 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/alerts"
 	"github.com/ttocsneb/station-webapp/database"
+	"github.com/ttocsneb/station-webapp/station"
+	"github.com/ttocsneb/station-webapp/weather"
 )
 
-func serveMain(db *sql.DB) http.HandlerFunc {
+// Get the forecast of a station, or nil if there isn't enough data for one
+func fetchForecast(db *sql.DB, client *station.Station) *weather.Forecast {
+	forecast, exists, err := weather.FetchForecast(db, client.Id(), client.Latitude(), client.Elevation(), time.Now())
+	if err != nil {
+		logrus.Errorf("Could not forecast the weather: %v", err)
+		return nil
+	}
+	if !exists {
+		return nil
+	}
+	return &forecast
+}
+
+func serveMain(db *sql.DB, client *station.Station, prefix string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
 
-		condition, err := database.FetchLatestCondition(db)
-		if err != nil {
+		// Stations that haven't reported yet show that they are offline
+		condition, err := database.FetchLatestCondition(db, client.Id())
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			logError(w, err)
 			return
 		}
@@ -30,12 +47,18 @@ func serveMain(db *sql.DB) http.HandlerFunc {
 			system = cookie.Value
 		}
 
-		logrus.Info(system)
-
 		err = renderTemplate(w, "main.html", vars{
 			"Condition": condition,
 			"System":    system,
 			"Page":      r.URL.Path,
+			"Nav":       "main",
+			"Title":     client.Name(),
+			"Station":   client,
+			"Stations":  station.All(),
+			"Prefix":    prefix,
+			"Forecast":  fetchForecast(db, client),
+			"Alerts":    alerts.Active(client.Id()),
+			"Status":    client.Status(),
 		})
 
 		if err != nil {
@@ -46,12 +69,13 @@ func serveMain(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func serveRapid(db *sql.DB) http.HandlerFunc {
+func serveRapid(db *sql.DB, client *station.Station, prefix string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
 
-		condition, err := database.FetchLatestCondition(db)
-		if err != nil {
+		// Stations that haven't reported yet show that they are offline
+		condition, err := database.FetchLatestCondition(db, client.Id())
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			logError(w, err)
 			return
 		}
@@ -62,13 +86,66 @@ func serveRapid(db *sql.DB) http.HandlerFunc {
 			system = cookie.Value
 		}
 
-		logrus.Info(system)
-
 		err = renderTemplate(w, "main.html", vars{
 			"Condition": condition,
 			"System":    system,
 			"Page":      r.URL.Path,
+			"Nav":       "rapid",
+			"Title":     client.Name(),
+			"Station":   client,
+			"Stations":  station.All(),
+			"Prefix":    prefix,
 			"Rapid":     true,
+			"Status":    client.Status(),
+		})
+
+		if err != nil {
+			logError(w, err)
+			w.Write([]byte("<p>Invalid template</p>"))
+			return
+		}
+	}
+}
+
+func serveIndex(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("system")
+		system := METRIC
+		if err == nil {
+			system = cookie.Value
+		}
+
+		type stationSummary struct {
+			Station   *station.Station
+			Prefix    string
+			Condition database.Condition
+			Exists    bool
+		}
+
+		clients := station.All()
+		summaries := make([]stationSummary, len(clients))
+		for i, client := range clients {
+			condition, err := database.FetchLatestCondition(db, client.Id())
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				logError(w, err)
+				return
+			}
+			summaries[i] = stationSummary{
+				Station:   client,
+				Prefix:    stationPrefix(client),
+				Condition: condition,
+				Exists:    err == nil,
+			}
+		}
+
+		err = renderTemplate(w, "index.html", vars{
+			"Title":     "Stations",
+			"System":    system,
+			"Page":      r.URL.Path,
+			"Nav":       "index",
+			"Prefix":    "",
+			"Stations":  station.All(),
+			"Summaries": summaries,
 		})
 
 		if err != nil {
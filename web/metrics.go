@@ -0,0 +1,86 @@
+package web
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/ttocsneb/station-webapp/metrics"
+	"github.com/ttocsneb/station-webapp/util"
+)
+
+var httpRequests = metrics.NewCounter(
+	"http_requests_total", "Requests served by status code",
+	"method", "code",
+)
+
+var httpDuration = metrics.NewHistogram(
+	"http_request_duration_seconds", "How long requests take to serve, including server-sent events",
+	metrics.DefaultBuckets, "method",
+)
+
+// A stream of server-sent events, which is shared by everyone watching a
+// station in the same system
+type sseStream struct {
+	station string
+	stream  string
+	system  string
+	mux     *util.ChanMux[[]byte]
+}
+
+var sseStreams = struct {
+	streams []sseStream
+	sync.Mutex
+}{}
+
+func registerStream(stream sseStream) {
+	sseStreams.Lock()
+	defer sseStreams.Unlock()
+	sseStreams.streams = append(sseStreams.streams, stream)
+}
+
+var _ = metrics.NewGaugeFunc(
+	"web_sse_subscribers", "Clients watching server-sent events",
+	[]string{"station", "stream", "system"},
+	func(set func(float64, ...string)) {
+		sseStreams.Lock()
+		defer sseStreams.Unlock()
+		for _, stream := range sseStreams.streams {
+			set(float64(stream.mux.Len()), stream.station, stream.stream, stream.system)
+		}
+	},
+)
+
+var _ = metrics.NewGaugeFunc(
+	"web_static_cache_files", "Static files that are cached",
+	nil,
+	func(set func(float64, ...string)) {
+		static_lock.RLock()
+		defer static_lock.RUnlock()
+		set(float64(len(static_cache)))
+	},
+)
+
+var _ = metrics.NewGaugeFunc(
+	"web_static_cache_bytes", "Size of the cached static files",
+	nil,
+	func(set func(float64, ...string)) {
+		static_lock.RLock()
+		defer static_lock.RUnlock()
+		size := 0
+		for _, file := range static_cache {
+			size += len(file.Data)
+		}
+		set(float64(size))
+	},
+)
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	buf := util.BufPool.Get()
+	defer util.BufPool.Put(buf)
+	if err := metrics.Write(buf); err != nil {
+		logError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ttocsneb/station-webapp/reports"
+	"github.com/ttocsneb/station-webapp/station"
+)
+
+// Serve the NOAA-style summary of a month (/reports/2024-06.txt) or a year
+// (/reports/2024.txt)
+func serveReport(db *sql.DB, client *station.Station) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Invalid Request", 400)
+			return
+		}
+
+		system := r.Form.Get("system")
+		if system == "" {
+			system = METRIC
+			if cookie, err := r.Cookie("system"); err == nil {
+				system = cookie.Value
+			}
+		}
+		if system != METRIC && system != IMPERIAL && system != MIXED {
+			http.Error(w, "Unknown system", 400)
+			return
+		}
+
+		params := mux.Vars(r)
+		year, _ := strconv.Atoi(params["year"])
+		report := reports.Report{
+			Station: client.Id(),
+			Name:    client.Name(),
+			System:  system,
+		}
+
+		var buf bytes.Buffer
+		if month, exists := params["month"]; exists {
+			m, _ := strconv.Atoi(month)
+			if m < 1 || m > 12 {
+				http.Error(w, "Invalid month", 400)
+				return
+			}
+			err = reports.Monthly(db, &buf, report, year, time.Month(m))
+		} else {
+			err = reports.Yearly(db, &buf, report, year)
+		}
+		if err != nil {
+			logError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(buf.Bytes())
+	}
+}
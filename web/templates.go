@@ -8,6 +8,7 @@ import (
 	"io/fs"
 	"mime"
 	"strings"
+	"sync"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/ttocsneb/station-webapp/util"
@@ -46,6 +47,7 @@ func readDirRecursive(fsys fs.FS, name string) ([]string, error) {
 }
 
 var templs map[string]*template.Template
+var templs_lock sync.RWMutex
 
 type vars map[string]any
 
@@ -59,7 +61,7 @@ func loadTemplates() error {
 		return err
 	}
 
-	templs = make(map[string]*template.Template)
+	loaded := make(map[string]*template.Template)
 
 	for _, layout := range layouts {
 		name := layout[strings.LastIndexByte(layout, '/')+1:]
@@ -68,7 +70,7 @@ func loadTemplates() error {
 		if err != nil {
 			return err
 		}
-		templs[name] = templ
+		loaded[name] = templ
 	}
 
 	include_templ, err := template.New("").Funcs(funcs).ParseFS(templFiles, includes...)
@@ -77,21 +79,29 @@ func loadTemplates() error {
 	}
 	for _, include := range includes {
 		name := include[strings.LastIndexByte(include, '/')+1:]
-		templs[name] = include_templ
+		loaded[name] = include_templ
 	}
 
+	templs_lock.Lock()
+	defer templs_lock.Unlock()
+	templs = loaded
 	return nil
 }
 
 func renderTemplate(response io.Writer, name string, vars any) error {
 	var err error
-	if len(templs) == 0 {
+	templs_lock.RLock()
+	loaded := len(templs) != 0
+	templs_lock.RUnlock()
+	if !loaded {
 		err = loadTemplates()
 		if err != nil {
 			return err
 		}
 	}
+	templs_lock.RLock()
 	templ, exists := templs[name]
+	templs_lock.RUnlock()
 	if !exists {
 		return fmt.Errorf("Template %v does not exist", name)
 	}
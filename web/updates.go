@@ -2,9 +2,11 @@ package web
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 
 	"github.com/sirupsen/logrus"
+	"github.com/ttocsneb/station-webapp/alerts"
 	"github.com/ttocsneb/station-webapp/database"
 	"github.com/ttocsneb/station-webapp/station"
 	"github.com/ttocsneb/station-webapp/util"
@@ -20,8 +22,21 @@ type updateRenderer struct {
 	args        map[string]any
 }
 
+func (self *updateRenderer) render() {
+	self.args["Status"] = self.client.Status()
+	buf := util.BufPool.Get()
+	err := renderTemplate(buf, "update-partial.html", self.args)
+	if err != nil {
+		util.BufPool.Put(buf)
+		return
+	}
+	self.updates <- buf.Bytes()
+	util.BufPool.Put(buf)
+}
+
 func (self *updateRenderer) start() {
 	updates := self.subscribe()
+	statuses := self.client.SubscribeStatus()
 
 	go func() {
 		if _, exists := self.args["Rapid"]; exists {
@@ -31,19 +46,39 @@ func (self *updateRenderer) start() {
 		}
 		for {
 			select {
-			case update := <-updates:
+			case update, ok := <-updates:
+				if !ok {
+					self.close()
+					return
+				}
 				self.args["Condition"] = update
-
-				buf := util.BufPool.Get()
-				err := renderTemplate(buf, "update-partial.html", self.args)
-				if err != nil {
-					util.BufPool.Put(buf)
-					continue
+				if _, exists := self.args["Rapid"]; !exists {
+					self.args["Forecast"] = fetchForecast(self.db, self.client)
+					self.args["Alerts"] = alerts.Active(self.client.Id())
+				}
+				self.render()
+			case _, ok := <-statuses:
+				if !ok {
+					self.close()
+					return
 				}
-				self.updates <- buf.Bytes()
-				util.BufPool.Put(buf)
+				// Show the station going offline with the last conditions
+				if _, exists := self.args["Condition"]; !exists {
+					condition, err := database.FetchLatestCondition(self.db, self.client.Id())
+					if err != nil {
+						logrus.Error(err)
+						continue
+					}
+					self.args["Condition"] = condition
+					if _, exists := self.args["Rapid"]; !exists {
+						self.args["Forecast"] = fetchForecast(self.db, self.client)
+						self.args["Alerts"] = alerts.Active(self.client.Id())
+					}
+				}
+				self.render()
 			case <-self.done:
 				self.unsubscribe(updates)
+				self.client.UnsubscribeStatus(statuses)
 				if _, exists := self.args["Rapid"]; exists {
 					logrus.Infof("Stopping %v rapid updates", self.args["System"])
 				} else {
@@ -59,6 +94,12 @@ func (self *updateRenderer) stop() {
 	self.done <- true
 }
 
+// The station has closed, so every stream of updates ends
+func (self *updateRenderer) close() {
+	logrus.Infof("Closing %v updates", self.args["System"])
+	close(self.updates)
+}
+
 func serveUpdates(db *sql.DB, client *station.Station) http.Handler {
 	var muxes = make(map[string]*util.ChanMux[[]byte])
 
@@ -69,12 +110,18 @@ func serveUpdates(db *sql.DB, client *station.Station) http.Handler {
 			subscribe:   client.SubscribeUpdates,
 			unsubscribe: client.UnsubscribeUpdates,
 			updates:     make(chan []byte),
-			done:        make(chan any),
+			done:        make(chan any, 1),
 			args:        map[string]any{"System": system},
 		}
 		mux := util.NewChanMux(updator.updates)
 		mux.OnSubscribe = updator.start
 		mux.OnEmpty = updator.stop
+		registerStream(sseStream{
+			station: client.Id(),
+			stream:  "updates",
+			system:  system,
+			mux:     mux,
+		})
 		return mux
 	}
 
@@ -95,8 +142,8 @@ func serveUpdates(db *sql.DB, client *station.Station) http.Handler {
 			return
 		}
 
-		condition, err := database.FetchLatestCondition(db)
-		if err != nil {
+		condition, err := database.FetchLatestCondition(db, client.Id())
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			logrus.Error(err)
 			w.WriteHeader(500)
 			return
@@ -105,6 +152,9 @@ func serveUpdates(db *sql.DB, client *station.Station) http.Handler {
 		args := map[string]any{
 			"Condition": condition,
 			"System":    system,
+			"Forecast":  fetchForecast(db, client),
+			"Alerts":    alerts.Active(client.Id()),
+			"Status":    client.Status(),
 		}
 
 		buf := util.BufPool.Get()
@@ -118,7 +168,12 @@ func serveUpdates(db *sql.DB, client *station.Station) http.Handler {
 		msg := buf.Bytes()
 		util.BufPool.Put(buf)
 
+		// The updates have closed once the station is closed
 		data := mux.Subscribe(2)
+		if data == nil {
+			http.Error(w, "Shutting down", 503)
+			return
+		}
 		data <- msg
 
 		util.RunSse(w, r, data, func() {
@@ -137,7 +192,7 @@ func serveRapidUpdates(db *sql.DB, client *station.Station) http.Handler {
 			subscribe:   client.SubscribeRapid,
 			unsubscribe: client.UnsubscribeRapid,
 			updates:     make(chan []byte),
-			done:        make(chan any),
+			done:        make(chan any, 1),
 			args: map[string]any{
 				"System": system,
 				"Rapid":  true,
@@ -146,6 +201,12 @@ func serveRapidUpdates(db *sql.DB, client *station.Station) http.Handler {
 		mux := util.NewChanMux(updator.updates)
 		mux.OnSubscribe = updator.start
 		mux.OnEmpty = updator.stop
+		registerStream(sseStream{
+			station: client.Id(),
+			stream:  "rapid",
+			system:  system,
+			mux:     mux,
+		})
 		return mux
 	}
 
@@ -166,8 +227,8 @@ func serveRapidUpdates(db *sql.DB, client *station.Station) http.Handler {
 			return
 		}
 
-		condition, err := database.FetchLatestCondition(db)
-		if err != nil {
+		condition, err := database.FetchLatestCondition(db, client.Id())
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			logrus.Error(err)
 			w.WriteHeader(500)
 			return
@@ -176,6 +237,7 @@ func serveRapidUpdates(db *sql.DB, client *station.Station) http.Handler {
 		args := map[string]any{
 			"Condition": condition,
 			"System":    system,
+			"Status":    client.Status(),
 		}
 
 		buf := util.BufPool.Get()
@@ -189,7 +251,12 @@ func serveRapidUpdates(db *sql.DB, client *station.Station) http.Handler {
 		msg := buf.Bytes()
 		util.BufPool.Put(buf)
 
+		// The updates have closed once the station is closed
 		data := mux.Subscribe(2)
+		if data == nil {
+			http.Error(w, "Shutting down", 503)
+			return
+		}
 		data <- msg
 
 		util.RunSse(w, r, data, func() {
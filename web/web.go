@@ -1,9 +1,13 @@
 package web
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -16,35 +20,117 @@ type embedFunc func(w io.Writer, arg []any) error
 
 var embedFuncs = map[string]embedFunc{}
 
-func Main(db *sql.DB, client *station.Station) {
+// Get the prefix to every route of a station. When there is only one station,
+// its routes are at the root.
+func stationPrefix(client *station.Station) string {
+	if len(station.All()) == 1 {
+		return ""
+	}
+	return fmt.Sprintf("/s/%v", client.Id())
+}
+
+func registerStation(router *mux.Router, db *sql.DB, client *station.Station, prefixes ...string) {
+	prefix := stationPrefix(client)
+	main := serveMain(db, client, prefix)
+	rapid := serveRapid(db, client, prefix)
+	history := serveHistory(db, client, prefix)
+	almanac := serveAlmanac(db, client, prefix)
+	report := serveReport(db, client)
+	updates := serveUpdates(db, client)
+	rapid_updates := serveRapidUpdates(db, client)
+
+	for _, prefix := range prefixes {
+		// With multiple stations, the root is an index of every station
+		if prefix != "" || len(station.All()) == 1 {
+			router.Handle(prefix+"/", main)
+		}
+		router.Handle(prefix+"/rapid/", rapid)
+		router.Handle(prefix+"/history/", history)
+		router.Handle(prefix+"/almanac/", almanac)
+		router.Handle(prefix+"/reports/{year:[0-9]{4}}-{month:[0-9]{2}}.txt", report)
+		router.Handle(prefix+"/reports/{year:[0-9]{4}}.txt", report)
+		router.Handle(prefix+"/sse/updates/", updates)
+		router.Handle(prefix+"/sse/rapid-updates/", rapid_updates)
+	}
+}
+
+// Main serves the app until ctx is done. Shutting down waits for the requests
+// in progress, and on_shutdown is called as it starts, which has to close the
+// stations so that the server-sent events end. reload is called by the admin
+// endpoint to reload the config.
+func Main(
+	ctx context.Context, db *sql.DB, stations []*station.Station,
+	on_shutdown func(), reload func() (ReloadReport, error),
+) error {
 	router := mux.NewRouter()
 
 	err := loadTemplates()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	router.PathPrefix("/static/").Handler(http.HandlerFunc(serveStatic))
-	router.HandleFunc("/", serveMain(db))
-	router.HandleFunc("/rapid/", serveRapid(db))
-	router.Handle("/sse/updates/", serveUpdates(db, client))
-	router.Handle("/sse/rapid-updates/", serveRapidUpdates(db, client))
+	for i, client := range stations {
+		prefixes := []string{fmt.Sprintf("/s/%v", client.Id())}
+		if i == 0 {
+			// The first station keeps the routes from before there were
+			// multiple stations
+			prefixes = append(prefixes, "")
+		}
+		registerStation(router, db, client, prefixes...)
+	}
+	if len(stations) != 1 {
+		router.HandleFunc("/", serveIndex(db))
+	}
+	router.HandleFunc("/s/", serveIndex(db))
 	router.HandleFunc("/system/", serveSystemForm)
 	router.HandleFunc("/dynamic/wind.svg", serveWind)
+	router.HandleFunc("/metrics", serveMetrics)
+	router.HandleFunc("/healthz", serveHealthz)
+	router.HandleFunc("/readyz", serveReadyz)
+	router.HandleFunc("/admin/reload", serveReload(reload)).Methods("POST")
+	router.HandleFunc("/weatherstation/updateweatherstation.php", serveWunderground).Methods("GET", "POST")
+	router.HandleFunc("/data/report/", serveEcowitt).Methods("POST")
+	registerApi(router, db)
 	embedFuncs["wind.svg"] = embedWind
 
-	log.Infof("Listening on %v", util.Conf.Listen)
-	err = http.ListenAndServe(util.Conf.Listen, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		wrapper := &responseWriterWrapper{
-			ResponseWriter: w,
-			sentHeader:     false,
-			request:        r,
-		}
-		router.ServeHTTP(wrapper, r)
-	}))
-	if err != nil {
-		panic(err)
+	server := &http.Server{
+		Addr: util.Conf().Listen,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			wrapper := &responseWriterWrapper{
+				ResponseWriter: w,
+				sentHeader:     false,
+				status:         200,
+				request:        r,
+			}
+			router.ServeHTTP(wrapper, r)
+			httpRequests.Inc(r.Method, strconv.Itoa(wrapper.status))
+			httpDuration.Observe(time.Since(start).Seconds(), r.Method)
+		}),
+	}
+	server.RegisterOnShutdown(on_shutdown)
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Infof("Listening on %v", server.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Info("Shutting down the web server")
+	shutdown, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		log.Warnf("Closing the requests that are still open: %v", err)
+		return server.Close()
 	}
+	return nil
 }
 
 func logError(w http.ResponseWriter, err error) {
@@ -55,12 +141,14 @@ func logError(w http.ResponseWriter, err error) {
 type responseWriterWrapper struct {
 	http.ResponseWriter
 	sentHeader bool
+	status     int
 	request    *http.Request
 }
 
 func (w *responseWriterWrapper) WriteHeader(status int) {
 	if !w.sentHeader {
 		log.Infof("%v\t[%v]\t%v", w.request.Method, status, w.request.URL.Path)
+		w.status = status
 	}
 	w.sentHeader = true
 	w.ResponseWriter.WriteHeader(status)